@@ -0,0 +1,224 @@
+// Package aiff writes and reads AIFF and AIFF-C sound files.
+package aiff
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/arl/blip/internal/patch"
+)
+
+// ErrTooLarge is returned when writing more sample data than an AIFF file can
+// hold.
+var ErrTooLarge = errors.New("aiff: file too large")
+
+// A Writer writes samples to an AIFF file.
+//
+// Samples are written as 16-bit big-endian PCM by default. Other sample
+// formats and channel counts can be selected with SetFormat and SetChannels,
+// before the first sample is written. The Float32 and PCM16LE formats are
+// written as AIFF-C files, the others as plain AIFF files.
+//
+// Sample data is streamed to the destination as it's written. The header
+// holds the size of the sample data though, which is only known when the
+// Writer is closed. If the destination is an io.WriteSeeker, such as the
+// *os.File created by NewFile, the header is patched on Close. Otherwise, the
+// sample data is buffered in memory until Close, where the header can be
+// written first.
+type Writer struct {
+	out         *patch.Writer
+	sampleRate  int
+	sampleCount int
+	chanCount   int
+	format      SampleFormat
+
+	buf [4096]byte
+}
+
+func newWriter(out *patch.Writer, sampleRate int) *Writer {
+	return &Writer{
+		out:        out,
+		sampleRate: sampleRate,
+		chanCount:  1,
+	}
+}
+
+// NewWriter creates a new Writer with the given sample rate, onto which samples
+// can be written with Write. Close must be called when done writing samples to
+// finalize the AIFF file. Close doesn't close w.
+func NewWriter(w io.Writer, sampleRate int) *Writer {
+	return newWriter(patch.New(w), sampleRate)
+}
+
+// NewFile creates a new AIFF file at the given path with the given sample
+// rate. Close must be called when done writing samples to finalize the file.
+func NewFile(path string, sampleRate int) (*Writer, error) {
+	out, err := patch.NewFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(out, sampleRate), nil
+}
+
+func (w *Writer) dataSize() int64 {
+	return int64(w.sampleCount) * int64(w.format.Size())
+}
+
+// maxDataSize is the maximum size of sample data, leaving room for the header
+// in the 32-bit size of the FORM chunk.
+var maxDataSize int64 = math.MaxUint32 - 256
+
+func (w *Writer) header() []byte {
+	be := binary.BigEndian
+	size := w.format.Size()
+	dataSize := w.dataSize()
+	compression, name := w.format.compression()
+
+	h := make([]byte, 0, 128)
+	h = append(h, "FORM"...)
+	h = be.AppendUint32(h, 0) // length of rest of file
+	if compression == "" {
+		h = append(h, "AIFF"...)
+	} else {
+		h = append(h, "AIFC"...)
+		h = append(h, "FVER"...)
+		h = be.AppendUint32(h, 4)
+		h = be.AppendUint32(h, 0xA2805140) // AIFF-C version 1
+	}
+
+	h = append(h, "COMM"...)
+	comm := len(h)
+	h = be.AppendUint32(h, 0) // chunk size
+	h = be.AppendUint16(h, uint16(w.chanCount))
+	h = be.AppendUint32(h, uint32(w.sampleCount/w.chanCount)) // sample frames
+	h = be.AppendUint16(h, uint16(size*8))                    // bits per sample
+	h = appendExtended(h, float64(w.sampleRate))
+	if compression != "" {
+		h = append(h, compression...)
+		h = appendPString(h, name)
+	}
+	be.PutUint32(h[comm:], uint32(len(h)-comm-4))
+
+	h = append(h, "SSND"...)
+	h = be.AppendUint32(h, uint32(8+dataSize))
+	h = be.AppendUint32(h, 0) // offset
+	h = be.AppendUint32(h, 0) // block size
+	// ... sample data, padded to an even size
+
+	be.PutUint32(h[4:], uint32(int64(len(h)-8)+dataSize+dataSize&1))
+	return h
+}
+
+// appendPString appends s as a Pascal string, padded to an even size.
+func appendPString(b []byte, s string) []byte {
+	b = append(b, byte(len(s)))
+	b = append(b, s...)
+	if len(s)&1 == 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// EnableStereo sets the AIFF file to 2 interleaved channels.
+func (w *Writer) EnableStereo() {
+	w.SetChannels(2)
+}
+
+// SetChannels sets the number of interleaved channels. It panics if called
+// after samples have been written.
+func (w *Writer) SetChannels(n int) {
+	if n < 1 || n > math.MaxInt16 {
+		panic("aiff: invalid channel count")
+	}
+	w.checkNotStarted()
+	w.chanCount = n
+}
+
+// SetFormat sets the encoding of samples in the file. Samples passed to the
+// Write methods are converted to this format. It panics if called after
+// samples have been written.
+func (w *Writer) SetFormat(f SampleFormat) {
+	w.checkNotStarted()
+	w.format = f
+}
+
+func (w *Writer) checkNotStarted() {
+	if w.out.Started() {
+		panic("aiff: format changed after samples were written")
+	}
+}
+
+// SampleCount returns the number of samples written so far.
+func (w *Writer) SampleCount() int {
+	return w.sampleCount
+}
+
+// Write writes 16-bit signed samples. Multichannel samples must be
+// interleaved.
+func (w *Writer) Write(p []int16) (n int, err error) {
+	return write(w, p, func(b []byte, s int16) { w.format.putInt(b, int32(s)<<16) })
+}
+
+// WriteInt32 writes full scale 32-bit signed samples. Multichannel samples
+// must be interleaved.
+func (w *Writer) WriteInt32(p []int32) (n int, err error) {
+	return write(w, p, w.format.putInt)
+}
+
+// WriteFloat32 writes samples in the [-1, 1] range. Multichannel samples must
+// be interleaved.
+func (w *Writer) WriteFloat32(p []float32) (n int, err error) {
+	return write(w, p, w.format.putFloat)
+}
+
+// write encodes samples with put and writes them.
+func write[T any](w *Writer, p []T, put func([]byte, T)) (n int, err error) {
+	if !w.out.Started() {
+		if err := w.out.Begin(w.header()); err != nil {
+			return 0, err
+		}
+	}
+
+	size := w.format.Size()
+	for n < len(p) {
+		chunk := p[n:min(len(p), n+len(w.buf)/size)]
+		if w.dataSize()+int64(len(chunk)*size) > maxDataSize {
+			return n, ErrTooLarge
+		}
+		for i, s := range chunk {
+			put(w.buf[i*size:], s)
+		}
+		if _, err := w.out.Write(w.buf[:len(chunk)*size]); err != nil {
+			return n, err
+		}
+		n += len(chunk)
+		w.sampleCount += len(chunk)
+	}
+	return n, nil
+}
+
+// Close finalizes the AIFF file. It must be called when done writing samples.
+func (w *Writer) Close() error {
+	if err := w.finalize(); err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
+
+func (w *Writer) finalize() error {
+	if !w.out.Started() {
+		if err := w.out.Begin(w.header()); err != nil {
+			return err
+		}
+	}
+
+	if w.dataSize()&1 != 0 {
+		if _, err := w.out.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+	return w.out.Finish(w.header())
+}
@@ -0,0 +1,321 @@
+package aiff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arl/blip/internal/pcmtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestExtended(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{44100, "\x40\x0e\xac\x44\x00\x00\x00\x00\x00\x00"},
+		{48000, "\x40\x0e\xbb\x80\x00\x00\x00\x00\x00\x00"},
+		{8000, "\x40\x0b\xfa\x00\x00\x00\x00\x00\x00\x00"},
+		{1, "\x3f\xff\x80\x00\x00\x00\x00\x00\x00\x00"},
+	}
+	for _, tt := range tests {
+		got := appendExtended(nil, tt.v)
+		if string(got) != tt.want {
+			t.Errorf("appendExtended(%v) = % x, want % x", tt.v, got, tt.want)
+		}
+		if v := extended(got); v != tt.v {
+			t.Errorf("extended(% x) = %v, want %v", got, v, tt.v)
+		}
+	}
+
+	for _, v := range []float64{22050.5, 11025.25, 1e-3, 3579545} {
+		if got := extended(appendExtended(nil, v)); got != v {
+			t.Errorf("extended(appendExtended(%v)) = %v", v, got)
+		}
+	}
+}
+
+func TestWriterHeader(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.EnableStereo()
+	w.Write([]int16{0x0102, -2, 0x7fff})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "FORM\x00\x00\x00\x34AIFF" +
+		"COMM\x00\x00\x00\x12\x00\x02\x00\x00\x00\x01\x00\x10" +
+		"\x40\x0e\xac\x44\x00\x00\x00\x00\x00\x00" +
+		"SSND\x00\x00\x00\x0e\x00\x00\x00\x00\x00\x00\x00\x00" +
+		"\x01\x02\xff\xfe\x7f\xff"
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("file mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestWriterAIFC(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 48000)
+	w.SetFormat(Float32)
+	w.WriteFloat32([]float32{0.5})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if string(b[8:12]) != "AIFC" || string(b[12:16]) != "FVER" {
+		t.Fatalf("form type = %q, first chunk %q", b[8:12], b[12:16])
+	}
+	comm := b[24:]
+	if string(comm[:4]) != "COMM" {
+		t.Fatalf("chunk = %q, want COMM", comm[:4])
+	}
+	size := binary.BigEndian.Uint32(comm[4:])
+	if size != 18+4+22 || string(comm[26:30]) != "fl32" {
+		t.Errorf("COMM size = %d, compression = %q", size, comm[26:30])
+	}
+	if name := string(comm[31 : 31+comm[30]]); name != "32-bit floating point" {
+		t.Errorf("compression name = %q", name)
+	}
+	if got := binary.BigEndian.Uint32(b[len(b)-4:]); got != 0x3f000000 {
+		t.Errorf("sample = %#x, want 0.5", got)
+	}
+	if got := binary.BigEndian.Uint32(b[4:]); int(got) != len(b)-8 {
+		t.Errorf("FORM size = %d, want %d", got, len(b)-8)
+	}
+}
+
+func TestWriterSeekable(t *testing.T) {
+	samples := pcmtest.Samples(10001)
+
+	// Non-seekable destination, sample data is buffered.
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.SetFormat(PCM24)
+	if n, err := w.Write(samples); n != len(samples) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seekable destination, header is patched on Close.
+	path := filepath.Join(t.TempDir(), "out.aiff")
+	fw, err := NewFile(path, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.SetFormat(PCM24)
+	for i := 0; i < len(samples); i += 3000 {
+		fw.Write(samples[i:min(i+3000, len(samples))])
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Fatalf("seekable and non-seekable outputs differ")
+	}
+	// Odd sized sample data is padded.
+	if len(got)&1 != 0 {
+		t.Errorf("file size = %d, want even", len(got))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	samples := pcmtest.Samples(3000)
+	tests := []struct {
+		format SampleFormat
+		bits   int
+		float  bool
+		shift  int // low bits lost in conversion
+	}{
+		{PCM16, 16, false, 0},
+		{PCM8, 8, false, 8},
+		{PCM24, 24, false, 0},
+		{PCM32, 32, false, 0},
+		{Float32, 32, true, 0},
+		{PCM16LE, 16, false, 0},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, 32000)
+		w.SetChannels(3)
+		w.SetFormat(tt.format)
+		w.Write(samples)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatalf("format %d: %v", tt.format, err)
+		}
+		if r.Channels() != 3 || r.SampleRate() != 32000 || r.BitsPerSample() != tt.bits || r.Float() != tt.float {
+			t.Errorf("format %d: got %d channels, %d Hz, %d bits, float %v",
+				tt.format, r.Channels(), r.SampleRate(), r.BitsPerSample(), r.Float())
+		}
+		if r.SampleCount() != len(samples) {
+			t.Errorf("format %d: SampleCount = %d, want %d", tt.format, r.SampleCount(), len(samples))
+		}
+		got, err := r.ReadAllInt16()
+		if err != nil {
+			t.Fatalf("format %d: %v", tt.format, err)
+		}
+		want := make([]int16, len(samples))
+		for i, s := range samples {
+			want[i] = s >> tt.shift << tt.shift
+		}
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("format %d: samples mismatch (-got +want):\n%s", tt.format, diff)
+		}
+	}
+}
+
+func TestReaderFloat(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.SetFormat(PCM32)
+	w.WriteInt32([]int32{-1 << 31, 1 << 30, 0})
+	w.Close()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAllFloat32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []float32{-1, 0.5, 0}); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+}
+
+// buildAIFF builds an AIFF file with 1 channel of 16-bit samples, preceded by
+// the given chunks.
+func buildAIFF(form string, chunks string, data []byte) []byte {
+	var b []byte
+	b = append(b, "FORM\x00\x00\x00\x00"+form...)
+	b = append(b, chunks...)
+	b = append(b, "SSND"...)
+	b = binary.BigEndian.AppendUint32(b, uint32(12+len(data)))
+	b = append(b, "\x00\x00\x00\x04\x00\x00\x00\x00skip"...)
+	b = append(b, data...)
+	binary.BigEndian.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+const comm16 = "COMM\x00\x00\x00\x12\x00\x01\x00\x00\x00\x02\x00\x10\x40\x0e\xac\x44\x00\x00\x00\x00\x00\x00"
+
+func TestReader(t *testing.T) {
+	// Unknown chunks are skipped, and so is the SSND offset.
+	b := buildAIFF("AIFF", "ANNO\x00\x00\x00\x03abc\x00"+comm16, []byte{0x80, 0x00, 0x12, 0x34})
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAllInt16()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []int16{-32768, 0x1234}); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+	if _, err := r.ReadInt16(make([]int16, 1)); err != io.EOF {
+		t.Errorf("ReadInt16 at end = %v, want io.EOF", err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	ulaw := "COMM\x00\x00\x00\x18\x00\x01\x00\x00\x00\x01\x00\x10\x40\x0e\xac\x44\x00\x00\x00\x00\x00\x00ulaw\x00\x00"
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrFormat},
+		{"wave", []byte("RIFF\x00\x00\x00\x00WAVE"), ErrFormat},
+		{"no COMM", buildAIFF("AIFF", "", nil), ErrFormat},
+		{"short COMM", buildAIFF("AIFF", "COMM\x00\x00\x00\x02\x00\x01", nil), ErrFormat},
+		{"compressed", buildAIFF("AIFC", ulaw, nil), ErrUnsupported},
+		{"truncated", []byte("FORM\x00\x00\x00\x00AIFF" + comm16[:20]), ErrFormat},
+	}
+	for _, tt := range tests {
+		if _, err := NewReader(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Sample data shorter than announced.
+	b := buildAIFF("AIFF", comm16, []byte{1, 2, 3, 4})
+	r, err := NewReader(bytes.NewReader(b[:len(b)-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAllInt16(); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReaderHugeSizes(t *testing.T) {
+	// Chunks claiming the largest possible size.
+	for _, chunks := range []string{
+		"COMM\xff\xff\xff\xff" + comm16[8:],
+		comm16 + "MARK\xff\xff\xff\xff\x00\x00",
+	} {
+		b := buildAIFF("AIFF", chunks, nil)
+		var err error
+		alloc := pcmtest.Allocated(func() { _, err = NewReader(bytes.NewReader(b)) })
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("%q: err = %v, want ErrFormat", chunks[:4], err)
+		}
+		if alloc > 1<<20 {
+			t.Errorf("%q: allocated %d bytes", chunks[:4], alloc)
+		}
+	}
+
+	// The SSND chunk claims much more than the file holds.
+	b := buildAIFF("AIFF", comm16, make([]byte, 100))
+	binary.BigEndian.PutUint32(b[len(b)-116:], math.MaxUint32)
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int16
+	alloc := pcmtest.Allocated(func() { got, err = r.ReadAllInt16() })
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(got) != 50 {
+		t.Errorf("read %d samples, want 50", len(got))
+	}
+	if alloc > 1<<20 {
+		t.Errorf("allocated %d bytes", alloc)
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	defer func(old int64) { maxDataSize = old }(maxDataSize)
+	maxDataSize = 100
+
+	w := NewWriter(io.Discard, 44100)
+	n, err := w.Write(make([]int16, 51))
+	if n != 0 || err != ErrTooLarge {
+		t.Errorf("Write = %d, %v, want ErrTooLarge", n, err)
+	}
+	if n, err := w.Write(make([]int16, 50)); n != 50 || err != nil {
+		t.Errorf("Write = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,97 @@
+package aiff
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// SampleFormat is the encoding of samples in an AIFF file.
+type SampleFormat uint8
+
+const (
+	PCM16   SampleFormat = iota // 16-bit big-endian signed integer, the default
+	PCM8                        // 8-bit signed integer
+	PCM24                       // 24-bit big-endian signed integer
+	PCM32                       // 32-bit big-endian signed integer
+	Float32                     // 32-bit big-endian IEEE float, AIFF-C 'fl32'
+	PCM16LE                     // 16-bit little-endian signed integer, AIFF-C 'sowt'
+)
+
+// Size returns the size in bytes of a single sample.
+func (f SampleFormat) Size() int {
+	switch f {
+	case PCM8:
+		return 1
+	case PCM24:
+		return 3
+	case PCM32, Float32:
+		return 4
+	}
+	return 2
+}
+
+// compression returns the AIFF-C compression type and name of the format, or
+// an empty type if the format can be written as plain AIFF.
+func (f SampleFormat) compression() (id, name string) {
+	switch f {
+	case Float32:
+		return "fl32", "32-bit floating point"
+	case PCM16LE:
+		return "sowt", ""
+	}
+	return "", ""
+}
+
+// putInt encodes v, a full scale 32-bit signed sample, at the start of b.
+func (f SampleFormat) putInt(b []byte, v int32) {
+	switch f {
+	case PCM8:
+		b[0] = byte(v >> 24)
+	case PCM16:
+		binary.BigEndian.PutUint16(b, uint16(v>>16))
+	case PCM24:
+		b[0], b[1], b[2] = byte(v>>24), byte(v>>16), byte(v>>8)
+	case PCM32:
+		binary.BigEndian.PutUint32(b, uint32(v))
+	case Float32:
+		binary.BigEndian.PutUint32(b, math.Float32bits(float32(float64(v)/(1<<31))))
+	case PCM16LE:
+		binary.LittleEndian.PutUint16(b, uint16(v>>16))
+	}
+}
+
+// putFloat encodes v, a sample in the [-1, 1] range, at the start of b.
+// Integer formats clamp values out of range.
+func (f SampleFormat) putFloat(b []byte, v float32) {
+	if f == Float32 {
+		binary.BigEndian.PutUint32(b, math.Float32bits(v))
+		return
+	}
+	s := min(max(math.Round(float64(v)*(1<<31)), math.MinInt32), math.MaxInt32)
+	f.putInt(b, int32(s))
+}
+
+// appendExtended appends v as an 80-bit IEEE 754 extended precision float, as
+// used for the sample rate of the COMM chunk. v must be positive.
+func appendExtended(b []byte, v float64) []byte {
+	if v <= 0 {
+		return append(b, make([]byte, 10)...)
+	}
+	// v = frac * 2^exp, with frac in [0.5, 1). The extended format has an
+	// explicit integer bit, so the mantissa holds frac * 2^64.
+	frac, exp := math.Frexp(v)
+	b = binary.BigEndian.AppendUint16(b, uint16(exp-1+16383))
+	return binary.BigEndian.AppendUint64(b, uint64(math.Ldexp(frac, 64)))
+}
+
+// extended decodes the 80-bit IEEE 754 extended precision float at the start
+// of b.
+func extended(b []byte) float64 {
+	exp := int(binary.BigEndian.Uint16(b) & 0x7fff)
+	mant := binary.BigEndian.Uint64(b[2:])
+	v := math.Ldexp(float64(mant), exp-16383-63)
+	if b[0]&0x80 != 0 {
+		v = -v
+	}
+	return v
+}
@@ -0,0 +1,277 @@
+package aiff
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/arl/blip/internal/pcm"
+)
+
+var (
+	// ErrFormat is returned when reading data that isn't a valid AIFF file.
+	ErrFormat = errors.New("aiff: invalid format")
+
+	// ErrUnsupported is returned when reading a valid AIFF file whose sample
+	// format isn't supported.
+	ErrUnsupported = errors.New("aiff: unsupported sample format")
+)
+
+// A Reader reads samples from an AIFF or AIFF-C file.
+//
+// PCM files with 1 to 32 bits per sample are supported, as well as AIFF-C
+// files with the 'NONE', 'twos', 'sowt' and 'fl32' compression types. Samples
+// can be read in any of those formats as int16 or float32, they're converted
+// on the fly. Multichannel samples are interleaved.
+type Reader struct {
+	r io.Reader
+	c io.Closer // nil if the source isn't owned
+
+	channels   int
+	sampleRate int
+	bits       int
+	size       int  // bytes per sample
+	float      bool // samples are IEEE floats
+	little     bool // samples are little-endian
+
+	data pcm.Data // sample data of the SSND chunk
+}
+
+// NewReader creates a Reader reading an AIFF or AIFF-C file from r. It parses
+// the file header up to the start of sample data.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	rd := &Reader{r: br, data: pcm.Data{R: br}}
+	if err := rd.readHeader(); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Open opens the AIFF file at path for reading. Close must be called when done
+// reading samples.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.c = f
+	return r, nil
+}
+
+// Close closes the file opened by Open. It does nothing for a Reader created
+// with NewReader.
+func (r *Reader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// Channels returns the number of channels.
+func (r *Reader) Channels() int { return r.channels }
+
+// SampleRate returns the number of sample frames per second, rounded to the
+// nearest integer.
+func (r *Reader) SampleRate() int { return r.sampleRate }
+
+// BitsPerSample returns the size of a single sample in the file, in bits.
+func (r *Reader) BitsPerSample() int { return r.bits }
+
+// Float reports whether samples are stored as IEEE floats.
+func (r *Reader) Float() bool { return r.float }
+
+// SampleCount returns the number of samples (of all channels) that remain to
+// be read.
+func (r *Reader) SampleCount() int {
+	return int(r.data.Remain / int64(r.size))
+}
+
+func (r *Reader) readChunkHeader() (id string, size uint32, err error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return "", 0, err
+	}
+	return string(b[:4]), binary.BigEndian.Uint32(b[4:]), nil
+}
+
+// maxCommSize is the maximum size of the COMM chunk, which holds at most 22
+// bytes and a compression name of up to 255 characters.
+const maxCommSize = 1024
+
+func (r *Reader) readHeader() error {
+	var form [12]byte
+	if _, err := io.ReadFull(r.r, form[:]); err != nil {
+		return ErrFormat
+	}
+	if string(form[0:4]) != "FORM" {
+		return ErrFormat
+	}
+	var aifc bool
+	switch string(form[8:12]) {
+	case "AIFF":
+	case "AIFC":
+		aifc = true
+	default:
+		return ErrFormat
+	}
+
+	gotComm := false
+	for {
+		id, size, err := r.readChunkHeader()
+		if err != nil {
+			return fmt.Errorf("%w: missing SSND chunk", ErrFormat)
+		}
+
+		switch id {
+		case "COMM":
+			b, err := pcm.ReadChunk(r.r, size, maxCommSize)
+			if err == pcm.ErrTooLarge {
+				return fmt.Errorf("%w: COMM chunk too large", ErrFormat)
+			}
+			if err != nil {
+				return fmt.Errorf("%w: truncated COMM chunk", ErrFormat)
+			}
+			if err := r.readComm(b, aifc); err != nil {
+				return err
+			}
+			gotComm = true
+		case "SSND":
+			if !gotComm {
+				return fmt.Errorf("%w: SSND chunk before COMM chunk", ErrFormat)
+			}
+			var b [8]byte
+			if size < 8 {
+				return fmt.Errorf("%w: SSND chunk too short", ErrFormat)
+			}
+			if _, err := io.ReadFull(r.r, b[:]); err != nil {
+				return fmt.Errorf("%w: truncated SSND chunk", ErrFormat)
+			}
+			offset := binary.BigEndian.Uint32(b[:])
+			if offset > size-8 {
+				return fmt.Errorf("%w: SSND offset out of range", ErrFormat)
+			}
+			if _, err := io.CopyN(io.Discard, r.r, int64(offset)); err != nil {
+				return fmt.Errorf("%w: truncated SSND chunk", ErrFormat)
+			}
+			r.data.Remain = int64(size - 8 - offset)
+			return nil
+		default:
+			if err := pcm.SkipChunk(r.r, size); err != nil {
+				return fmt.Errorf("%w: truncated %s chunk", ErrFormat, id)
+			}
+		}
+	}
+}
+
+func (r *Reader) readComm(b []byte, aifc bool) error {
+	if len(b) < 18 || aifc && len(b) < 22 {
+		return fmt.Errorf("%w: COMM chunk too short", ErrFormat)
+	}
+	r.channels = int(binary.BigEndian.Uint16(b[0:]))
+	r.bits = int(binary.BigEndian.Uint16(b[6:]))
+	rate := extended(b[8:])
+	if r.channels == 0 || !(rate >= 1 && rate <= math.MaxInt32) {
+		return fmt.Errorf("%w: no channels or invalid sample rate", ErrFormat)
+	}
+	r.sampleRate = int(math.Round(rate))
+
+	compression := "NONE"
+	if aifc {
+		compression = string(b[18:22])
+	}
+	switch {
+	case (compression == "NONE" || compression == "twos") && r.bits >= 1 && r.bits <= 32:
+	case compression == "sowt" && r.bits >= 1 && r.bits <= 32:
+		r.little = true
+	case (compression == "fl32" || compression == "FL32") && r.bits == 32:
+		r.float = true
+	default:
+		return ErrUnsupported
+	}
+	// Samples are left-justified in whole bytes.
+	r.size = (r.bits + 7) / 8
+	return nil
+}
+
+// read reads the raw bytes of at most n samples.
+func (r *Reader) read(n int) ([]byte, error) {
+	return r.data.Read(n, r.size)
+}
+
+// sample decodes the sample at the start of b as a signed integer using all 32
+// bits, or as a float.
+func (r *Reader) sample(b []byte) (int32, float32) {
+	if r.float {
+		return 0, math.Float32frombits(binary.BigEndian.Uint32(b))
+	}
+	var v int32
+	for i := range r.size {
+		c := b[i]
+		if r.little {
+			c = b[r.size-1-i]
+		}
+		v |= int32(c) << (24 - 8*i)
+	}
+	return v, 0
+}
+
+// ReadInt16 reads at most len(p) samples into p, converting them to 16-bit
+// signed integers. It returns the number of samples read and io.EOF at the end
+// of sample data.
+func (r *Reader) ReadInt16(p []int16) (int, error) {
+	b, err := r.read(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := len(b) / r.size
+	for i := range n {
+		iv, fv := r.sample(b[i*r.size:])
+		if r.float {
+			p[i] = int16(min(max(math.Round(float64(fv)*32768), math.MinInt16), math.MaxInt16))
+			continue
+		}
+		p[i] = int16(iv >> 16)
+	}
+	return n, nil
+}
+
+// ReadFloat32 reads at most len(p) samples into p, converting them to floats
+// in the [-1, 1] range. It returns the number of samples read and io.EOF at
+// the end of sample data.
+func (r *Reader) ReadFloat32(p []float32) (int, error) {
+	b, err := r.read(len(p))
+	if err != nil {
+		return 0, err
+	}
+	n := len(b) / r.size
+	for i := range n {
+		iv, fv := r.sample(b[i*r.size:])
+		if !r.float {
+			fv = float32(float64(iv) / (1 << 31))
+		}
+		p[i] = fv
+	}
+	return n, nil
+}
+
+// ReadAllInt16 reads all remaining samples, converted to 16-bit signed
+// integers.
+func (r *Reader) ReadAllInt16() ([]int16, error) {
+	return pcm.ReadAll(r.ReadInt16, r.SampleCount())
+}
+
+// ReadAllFloat32 reads all remaining samples, converted to floats in the
+// [-1, 1] range.
+func (r *Reader) ReadAllFloat32() ([]float32, error) {
+	return pcm.ReadAll(r.ReadFloat32, r.SampleCount())
+}
@@ -0,0 +1,118 @@
+// Package audiofile creates and opens sound files, picking the container from
+// the file extension.
+//
+// Supported extensions are .wav and .wave for wave files, .aif and .aiff for
+// AIFF files, .aifc for AIFF-C files and .flac for FLAC files.
+package audiofile
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/arl/blip/aiff"
+	"github.com/arl/blip/flac"
+	"github.com/arl/blip/wave"
+)
+
+// ErrExtension is returned for file names whose extension doesn't match a
+// supported container.
+var ErrExtension = errors.New("audiofile: unknown file extension")
+
+// An Encoder writes samples to a sound file. It's implemented by
+// *wave.Writer, *aiff.Writer and *flac.Writer.
+type Encoder interface {
+	// SetChannels sets the number of interleaved channels. It must be called
+	// before samples are written.
+	SetChannels(n int)
+
+	// Write writes 16-bit signed samples.
+	Write(p []int16) (int, error)
+
+	// WriteInt32 writes full scale 32-bit signed samples.
+	WriteInt32(p []int32) (int, error)
+
+	// WriteFloat32 writes samples in the [-1, 1] range.
+	WriteFloat32(p []float32) (int, error)
+
+	// SampleCount returns the number of samples written so far.
+	SampleCount() int
+
+	// Close finalizes the file.
+	Close() error
+}
+
+// A Decoder reads samples from a sound file. It's implemented by
+// *wave.Reader, *aiff.Reader and *flac.Reader.
+type Decoder interface {
+	Channels() int
+	SampleRate() int
+	BitsPerSample() int
+	Float() bool
+
+	// SampleCount returns the number of samples that remain to be read, or -1
+	// if unknown.
+	SampleCount() int
+
+	// ReadInt16 reads samples converted to 16-bit signed integers. It returns
+	// io.EOF at the end of sample data.
+	ReadInt16(p []int16) (int, error)
+
+	// ReadFloat32 reads samples converted to floats in the [-1, 1] range. It
+	// returns io.EOF at the end of sample data.
+	ReadFloat32(p []float32) (int, error)
+
+	Close() error
+}
+
+var (
+	_ Encoder = (*wave.Writer)(nil)
+	_ Encoder = (*aiff.Writer)(nil)
+	_ Encoder = (*flac.Writer)(nil)
+	_ Decoder = (*wave.Reader)(nil)
+	_ Decoder = (*aiff.Reader)(nil)
+	_ Decoder = (*flac.Reader)(nil)
+)
+
+// Create creates a sound file at the given path with the given sample rate,
+// in the container matching its extension. Samples are written as 16-bit
+// PCM, except for AIFF-C files which use little-endian 16-bit PCM ('sowt'),
+// and FLAC files which are losslessly compressed. The concrete Encoder type
+// can be asserted to select another sample format.
+func Create(path string, sampleRate int) (Encoder, error) {
+	switch ext(path) {
+	case ".wav", ".wave":
+		return wave.NewFile(path, sampleRate)
+	case ".aif", ".aiff":
+		return aiff.NewFile(path, sampleRate)
+	case ".aifc":
+		w, err := aiff.NewFile(path, sampleRate)
+		if err != nil {
+			return nil, err
+		}
+		w.SetFormat(aiff.PCM16LE)
+		return w, nil
+	case ".flac":
+		return flac.NewFile(path, sampleRate)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrExtension, path)
+}
+
+// Open opens the sound file at path for reading, in the container matching
+// its extension.
+func Open(path string) (Decoder, error) {
+	switch ext(path) {
+	case ".wav", ".wave":
+		return wave.Open(path)
+	case ".aif", ".aiff", ".aifc":
+		return aiff.Open(path)
+	case ".flac":
+		return flac.Open(path)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrExtension, path)
+}
+
+func ext(path string) string {
+	return strings.ToLower(filepath.Ext(path))
+}
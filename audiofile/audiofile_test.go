@@ -0,0 +1,75 @@
+package audiofile
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCreateOpen(t *testing.T) {
+	samples := []int16{-32768, -100, 0, 100, 32767, 1}
+	tests := []struct {
+		name  string
+		magic string
+	}{
+		{"out.wav", "RIFF"},
+		{"out.WAVE", "RIFF"},
+		{"out.aiff", "FORM\x00\x00\x00\x3aAIFF"},
+		{"out.aif", "FORM\x00\x00\x00\x3aAIFF"},
+		{"out.aifc", "FORM\x00\x00\x00\x4cAIFC"},
+		{"out.flac", "fLaC"},
+	}
+	for _, tt := range tests {
+		path := filepath.Join(t.TempDir(), tt.name)
+		w, err := Create(path, 44100)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		w.SetChannels(2)
+		w.Write(samples)
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		b, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b[:len(tt.magic)]); got != tt.magic {
+			t.Errorf("%s: file starts with %q, want %q", tt.name, got, tt.magic)
+		}
+
+		r, err := Open(path)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r.Channels() != 2 || r.SampleRate() != 44100 || r.SampleCount() != len(samples) {
+			t.Errorf("%s: got %d channels, %d Hz, %d samples", tt.name, r.Channels(), r.SampleRate(), r.SampleCount())
+		}
+		got := make([]int16, len(samples))
+		n, err := r.ReadInt16(got)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if diff := cmp.Diff(got[:n], samples); diff != "" {
+			t.Errorf("%s: samples mismatch (-got +want):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func TestUnknownExtension(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.mp3")
+	if _, err := Create(path, 44100); !errors.Is(err, ErrExtension) {
+		t.Errorf("Create err = %v, want ErrExtension", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file was created")
+	}
+	if _, err := Open(path); !errors.Is(err, ErrExtension) {
+		t.Errorf("Open err = %v, want ErrExtension", err)
+	}
+}
@@ -54,22 +54,52 @@ func clamp[T ~int | ~int32 | ~int64](n T) T {
 // Buffer is a sample buffer that resamples to output rate and accumulates
 // samples until they're read out.
 type Buffer struct {
+	synthesis  Synthesis
+	clockRate  float64
 	factor     uint64
 	offset     uint64
 	avail      int
 	size       int
 	integrator int
 
+	produced   uint64 // samples made available since creation or Clear
+	frameStart uint64 // absolute clock time of the current time frame
+	frameFrac  uint64 // fraction of frameStart, with FracBits fraction bits
+
+	overflow OverflowPolicy
+	drain    func(*Buffer)
+	stats    OverflowStats
+	under    underrun
+
+	// Deltas waiting to be rendered, see SetDeferred.
+	deferred bool
+	pending  []queuedDelta
+	unsorted bool // whether pending isn't sorted by position
+
+	// Last rate change within the current time frame, see SetRatesAt.
+	pivot    uint64 // clock time of the change, 0 if none
+	pivotPos uint64 // buffer position of the change, in time units
+
+	// Slope changes, see AddSlope. ramp is nil until a slope is added.
+	ramp  []int64
+	slope int64 // current slope, after samples available
+
+	high int // end of the sample storage written to, see removeSamples
+
 	samples []int32
 }
 
 // NewBuffer creates a Buffer that can hold at most nsamples samples. Sets
 // rates so that there are [MaxRatio] clocks per sample.
-func NewBuffer(nsamples int) *Buffer {
+func NewBuffer(nsamples int, opts ...Option) *Buffer {
 	buf := &Buffer{
-		samples: make([]int32, nsamples+bufExtra),
-		factor:  timeUnit / MaxRatio,
-		size:    nsamples,
+		samples:   make([]int32, nsamples+bufExtra),
+		clockRate: MaxRatio,
+		factor:    timeUnit / MaxRatio,
+		size:      nsamples,
+	}
+	for _, opt := range opts {
+		opt(buf)
 	}
 	buf.Clear()
 	return buf
@@ -85,26 +115,102 @@ func (b *Buffer) Clear() {
 	b.offset = b.factor / 2
 	b.avail = 0
 	b.integrator = 0
+	b.pivot = 0
+	b.pivotPos = 0
+	b.produced = 0
+	b.frameStart = 0
+	b.frameFrac = 0
+	b.pending = b.pending[:0]
+	b.unsorted = false
+	b.ramp = nil
+	b.slope = 0
+	b.high = 0
 	clear(b.samples)
 }
 
+// Resize changes the maximum number of samples the buffer can hold,
+// preserving samples available for reading, deltas of the current time frame,
+// as well as time and filter state. It panics if nsamples is less than
+// SamplesAvailable, or if deltas of the current time frame don't fit.
+func (b *Buffer) Resize(nsamples int) {
+	// Fails if buffered samples don't fit
+	if nsamples < b.avail {
+		panic("buffer can't hold available samples")
+	}
+	for _, d := range b.samples[min(len(b.samples), nsamples+bufExtra):] {
+		if d != 0 {
+			panic("buffer can't hold pending deltas")
+		}
+	}
+	for _, d := range b.ramp[min(len(b.ramp), nsamples+bufExtra):] {
+		if d != 0 {
+			panic("buffer can't hold pending deltas")
+		}
+	}
+	b.realloc(nsamples)
+}
+
 // SetRates sets approximate input clock rate and output sample rate. For every
 // clockRate input clocks, approximately sampleRate samples are generated.
+//
+// Rates can be changed between time frames, after EndFrame, without clearing
+// the buffer: the position of the next time frame relative to output samples
+// doesn't depend on the rates, so the output stays phase-continuous. Use
+// SetRatesAt to change rates within a time frame.
 func (b *Buffer) SetRates(clockRate, sampleRate float64) {
+	b.factor = rateFactor(clockRate, sampleRate)
+	b.clockRate = clockRate
+}
+
+// SetRatesAt changes the rates at the given clock time of the current time
+// frame, for example when an emulated CPU switches to double speed. Clock
+// times before time keep the previous rates, clock times from time on follow
+// the new ones, continuing from the same output position.
+//
+// Deltas before time must be added before calling SetRatesAt, and the time
+// frame can't end before time. Several changes can be made in the same time
+// frame, in increasing time order.
+func (b *Buffer) SetRatesAt(time uint64, clockRate, sampleRate float64) {
+	if b.deferred {
+		panic("SetRatesAt isn't supported in deferred mode")
+	}
+
+	// Fails if rate changes are out of order
+	if time < b.pivot {
+		panic("rate changes must be in time order")
+	}
+
+	factor := rateFactor(clockRate, sampleRate)
+
+	// offset is chosen so that time*factor+offset is the position of time
+	// at the previous rates. It may wrap around, but positions computed for
+	// clock times after time don't.
+	pos := time*b.factor + b.offset
+	b.offset = pos - time*factor
+	b.factor = factor
+	b.clockRate = clockRate
+	b.pivot = time
+	b.pivotPos = pos
+}
+
+// rateFactor returns the buffer position increment per input clock, in time
+// units.
+func rateFactor(clockRate, sampleRate float64) uint64 {
 	factor := float64(timeUnit) * sampleRate / clockRate
-	b.factor = uint64(factor)
+	ifactor := uint64(factor)
 
 	// Fails if clockRate exceeds maximum, relative to sampleRate
-	if !(0 <= factor-float64(b.factor) && factor-float64(b.factor) < 1) {
+	if !(0 <= factor-float64(ifactor) && factor-float64(ifactor) < 1) {
 		panic("clock rate exceeds maximum")
 	}
 
-	if float64(b.factor) < factor {
-		b.factor++
+	if float64(ifactor) < factor {
+		ifactor++
 	}
 
 	// At this point, factor is most likely rounded up, but could still have
 	// been rounded down in the floating-point calculation.
+	return ifactor
 }
 
 // Length of time frame, in clocks, needed to make nsamples additional samples
@@ -112,13 +218,23 @@ func (b *Buffer) SetRates(clockRate, sampleRate float64) {
 func (b *Buffer) ClocksNeeded(nsamples int) int {
 	var needed uint64
 
+	if b.deferred {
+		return int(b.clocksNeededDeferred(nsamples, 0))
+	}
+
 	// Fails if buffer can't hold that many more samples
 	if nsamples < 0 || b.avail+nsamples > b.size {
 		panic("buffer can't hold that many samples")
 	}
 
 	needed = uint64(nsamples) * timeUnit
-	if needed < uint64(b.offset) {
+	if b.pivot > 0 {
+		// The time frame can't end before the last rate change, past which
+		// offset may have wrapped around.
+		if needed <= b.pivotPos {
+			return int(b.pivot)
+		}
+	} else if needed < uint64(b.offset) {
 		return 0
 	}
 
@@ -131,14 +247,51 @@ func (b *Buffer) ClocksNeeded(nsamples int) int {
 // old time frame specified. Deltas can have been added slightly past
 // clockDuration (up to how many clocks there are in two output samples).
 func (b *Buffer) EndFrame(clockDuration int) {
+	// Fails if the frame ends before the last rate change
+	if uint64(clockDuration) < b.pivot {
+		panic("time frame ends before rate change")
+	}
+	if b.deferred {
+		b.endFrameDeferred(uint64(clockDuration), 0)
+		return
+	}
 	off := uint64(clockDuration)*b.factor + b.offset
-	b.avail += int(off >> timeBits)
-	b.offset = off & (timeUnit - 1)
+	b.advance(int(off>>timeBits), off&(timeUnit-1), uint64(clockDuration)<<FracBits)
+}
+
+// advance ends the time frame, making count samples available, offset being
+// the position of the next time frame and clocks the fractional clock time
+// the frame lasted.
+func (b *Buffer) advance(count int, offset, clocks uint64) {
+	b.pivot = 0
+	b.pivotPos = 0
+
+	b.frameFrac += clocks & (FracUnit - 1)
+	b.frameStart += clocks>>FracBits + b.frameFrac>>FracBits
+	b.frameFrac &= FracUnit - 1
+
+	b.offset = offset
 
 	// Fails if buffer size was exceeded
-	if b.avail > b.size {
-		panic("buffer size exceeded")
+	if !b.deferred && !b.reserve(count) {
+		if b.overflow != OverflowDropNewest {
+			panic("buffer size exceeded")
+		}
+		// Drop the samples of this frame.
+		clear(b.samples[b.avail:])
+		if b.ramp != nil {
+			clear(b.ramp[b.avail:])
+		}
+		b.stats.DroppedFrames++
+		return
 	}
+
+	if b.ramp != nil {
+		b.integrateSlopes(count)
+	}
+
+	b.produced += uint64(count)
+	b.avail += count
 }
 
 // SamplesAvailable reports the number of buffered samples available for
@@ -148,18 +301,32 @@ func (b *Buffer) SamplesAvailable() int {
 }
 
 func (b *Buffer) removeSamples(count int) {
-	remain := b.avail + bufExtra - count
+	// Deltas of the current time frame are moved too, as samples may be
+	// removed before the frame ends (see OverflowDrain). Only the storage
+	// written to is moved.
+	end := max(b.avail+bufExtra, b.high)
 	b.avail -= count
+	b.high = max(b.high-count, 0)
+
+	n := min(end, len(b.samples))
+	copy(b.samples, b.samples[count:n])
+	clear(b.samples[n-count : n])
+	if b.ramp != nil {
+		n = min(end, len(b.ramp))
+		copy(b.ramp, b.ramp[count:n])
+		clear(b.ramp[n-count : n])
+	}
+}
 
-	copy(b.samples[:remain], b.samples[count:])
-	clear(b.samples[remain : remain+count])
+// written records that the sample storage, or the ramp storage, was written
+// up to end, exclusive.
+func (b *Buffer) written(end uint64) {
+	b.high = max(b.high, int(end))
 }
 
-// ReadSamples reads and removes at most count samples and writes them to 'out'.
-// If stereo is true, writes output to every other element of 'out', allowing
-// easy interleaving of two buffers into a stereo sample stream. Outputs 16-bit
-// signed samples. Returns number of samples actually read.
-func (b *Buffer) ReadSamples(out []int16, count int, stereo bool) int {
+// readCount returns the number of samples that can be read into an output
+// slice of length n, and the step between them.
+func (b *Buffer) readCount(n, count int, stereo bool) (int, int) {
 	if count < 0 {
 		panic("count must be positive")
 	}
@@ -167,36 +334,59 @@ func (b *Buffer) ReadSamples(out []int16, count int, stereo bool) int {
 	if count > b.avail {
 		count = b.avail
 	}
+	if b.deferred {
+		b.flush()
+		count = min(count, b.size)
+	}
 
 	step := 2
 	if !stereo {
 		step = 1
 	}
 
-	// Cap the number of samples as ceil(len(out)/step). Ceil takes care of odd
+	// Cap the number of samples as ceil(n/step). Ceil takes care of odd
 	// number of samples in stereo mode.
-	if maxout := (len(out) + step - 1) / step; count > maxout {
+	if maxout := (n + step - 1) / step; count > maxout {
 		count = maxout
 	}
+	return count, step
+}
 
+// ReadSamples reads and removes at most count samples and writes them to 'out'.
+// If stereo is true, writes output to every other element of 'out', allowing
+// easy interleaving of two buffers into a stereo sample stream. Outputs 16-bit
+// signed samples. Returns number of samples actually read.
+func (b *Buffer) ReadSamples(out []int16, count int, stereo bool) int {
+	count, step := b.readCount(len(out), count, stereo)
 	if count == 0 {
 		return 0
 	}
 
+	b.integrate(count, func(i, s int) { out[i*step] = int16(clamp(s)) })
+	return count
+}
+
+// integrate integrates and removes count samples, passing each one to f,
+// unclamped, along with its index. The last one is recorded for
+// ReadSamplesFill.
+func (b *Buffer) integrate(count int, f func(i, s int)) {
 	sum := b.integrator
+	s := 0
 	for idx := range b.samples[:count] {
 		// Eliminate fraction
-		s := sum >> deltaBits
+		s = sum >> deltaBits
 		sum += int(b.samples[idx])
 
-		out[idx*step] = int16(clamp(s))
+		f(idx, s)
 
 		// High-pass filter
 		sum -= s << (deltaBits - bassShift)
 	}
 	b.integrator = sum
 	b.removeSamples(count)
-	return count
+	if count > 0 {
+		b.under.last = int16(clamp(s))
+	}
 }
 
 // Sinc_Generator( 0.9, 0.55, 4.5 )
@@ -237,8 +427,33 @@ var blStep = [(phaseCount + 1) * halfWidth]int16{
 }
 
 // AddDelta adds positive/negative delta into buffer at specified clock time.
-func (bl Buffer) AddDelta(time uint64, delta int32) {
-	fixed := ((time*bl.factor + bl.offset) >> preShift)
+func (bl *Buffer) AddDelta(time uint64, delta int32) {
+	if bl.deferred {
+		bl.queue(time, 0, delta, stepDelta)
+		return
+	}
+	bl.addDelta((time*bl.factor+bl.offset)>>preShift, delta)
+}
+
+// addDelta adds delta at the given buffer position, in units of 1<<preShift
+// time units.
+func (bl *Buffer) addDelta(fixed uint64, delta int32) {
+	// Fails if buffer size was exceeded
+	if uint64(bl.avail)+(fixed>>fracBits) > uint64(bl.size)+endFrameExtra {
+		if !bl.reserveDelta(fixed >> fracBits) {
+			return
+		}
+	}
+	bl.render(uint64(bl.avail)<<fracBits+fixed, delta)
+}
+
+// render adds delta at the given position of the sample storage, in units of
+// 1<<preShift time units.
+func (bl *Buffer) render(fixed uint64, delta int32) {
+	if bl.synthesis != BandLimited {
+		bl.renderFast(fixed, delta)
+		return
+	}
 
 	const phaseShift = fracBits - phaseBits
 	phase := fixed >> phaseShift & (phaseCount - 1)
@@ -247,12 +462,8 @@ func (bl Buffer) AddDelta(time uint64, delta int32) {
 	delta2 := (delta * int32(interp)) >> deltaBits
 	delta -= delta2
 
-	// Fails if buffer size was exceeded
-	if uint64(bl.avail)+(fixed>>fracBits) > uint64(bl.size)+endFrameExtra {
-		panic("buffer exceeded")
-	}
-
-	out := bl.samples[uint64(bl.avail)+(fixed>>fracBits):]
+	out := bl.samples[fixed>>fracBits:]
+	bl.written(fixed>>fracBits + 2*halfWidth)
 
 	idx := phase * halfWidth
 
@@ -278,18 +489,36 @@ func (bl Buffer) AddDelta(time uint64, delta int32) {
 }
 
 // AddDeltaFast is like AddDelta but uses faster, lower-quality synthesis.
-func (bl Buffer) AddDeltaFast(time uint64, delta int32) {
-	fixed := ((time*bl.factor + bl.offset) >> preShift)
-
-	interp := fixed >> (fracBits - deltaBits) & (deltaUnit - 1)
-	delta2 := (delta * int32(interp))
+func (bl *Buffer) AddDeltaFast(time uint64, delta int32) {
+	if bl.deferred {
+		bl.queue(time, 0, delta, fastDelta)
+		return
+	}
+	bl.addDeltaFast((time*bl.factor+bl.offset)>>preShift, delta)
+}
 
+func (bl *Buffer) addDeltaFast(fixed uint64, delta int32) {
 	// Fails if buffer size was exceeded
 	if uint64(bl.avail)+(fixed>>fracBits) > uint64(bl.size)+endFrameExtra {
-		panic("buffer exceeded")
+		if !bl.reserveDelta(fixed >> fracBits) {
+			return
+		}
 	}
+	bl.renderFast(uint64(bl.avail)<<fracBits+fixed, delta)
+}
+
+// renderFast is like render, with the synthesis of AddDeltaFast.
+func (bl *Buffer) renderFast(fixed uint64, delta int32) {
+	if bl.synthesis == ZeroOrderHold {
+		bl.renderHold(fixed, delta)
+		return
+	}
+
+	interp := fixed >> (fracBits - deltaBits) & (deltaUnit - 1)
+	delta2 := (delta * int32(interp))
 
-	out := bl.samples[uint64(bl.avail)+(fixed>>fracBits):]
+	out := bl.samples[fixed>>fracBits:]
+	bl.written(fixed>>fracBits + halfWidth + 1)
 	out[7] += delta*deltaUnit - delta2
 	out[8] += delta2
 }
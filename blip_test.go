@@ -274,6 +274,137 @@ func TestSetRates(t *testing.T) {
 	})
 }
 
+// renderSpeedSwitch renders a square wave with a half period of 1000 clocks at
+// clockRate, the clock rate doubling at clock switchAt, with the emulated wave
+// keeping its frequency, as when a CPU switches to double speed.
+func renderSpeedSwitch(clockRate float64, switchAt int) []int16 {
+	const (
+		frameLen = 20000
+		frames   = 20
+		half     = 1000
+	)
+
+	// clocks returns the number of clocks elapsed at time x, in clocks at the
+	// original rate.
+	clocks := func(x int) int {
+		if x <= switchAt {
+			return x
+		}
+		return switchAt + 2*(x-switchAt)
+	}
+
+	bl := NewBuffer(MaxFrame)
+	bl.SetRates(clockRate, 44100)
+
+	var out []int16
+	buf := make([]int16, MaxFrame)
+	amp := int32(10000)
+	next := 0
+	switched := false
+	for f := range frames {
+		start := f * frameLen
+		end := start + frameLen
+		if start == switchAt {
+			bl.SetRates(2*clockRate, 44100)
+		}
+		for ; next < end; next += half {
+			if start < switchAt && switchAt < end && next >= switchAt && !switched {
+				bl.SetRatesAt(uint64(clocks(switchAt)-clocks(start)), 2*clockRate, 44100)
+				switched = true
+			}
+			bl.AddDelta(uint64(clocks(next)-clocks(start)), amp)
+			amp = -amp
+		}
+		bl.EndFrame(clocks(end) - clocks(start))
+		n := bl.ReadSamples(buf, len(buf), Mono)
+		out = append(out, buf[:n]...)
+	}
+	return out
+}
+
+func TestSetRatesAt(t *testing.T) {
+	for _, clockRate := range []float64{1 << 20, 1789773} {
+		want := renderSpeedSwitch(clockRate, math.MaxInt)
+		for _, switchAt := range []int{100000, 104500, 113333} {
+			got := renderSpeedSwitch(clockRate, switchAt)
+			if len(got) != len(want) {
+				t.Fatalf("rate %v switch at %d: got %d samples, want %d", clockRate, switchAt, len(got), len(want))
+			}
+			for i := range got {
+				if d := int(got[i]) - int(want[i]); d < -1 || d > 1 {
+					t.Fatalf("rate %v switch at %d: sample %d = %d, want %d", clockRate, switchAt, i, got[i], want[i])
+				}
+			}
+		}
+	}
+
+	t.Run("clocks needed", func(t *testing.T) {
+		bl := NewBuffer(100)
+		bl.SetRates(1000, 100)
+		bl.SetRatesAt(200, 2000, 100)
+
+		// 20 samples at 10 clocks per sample, then 20 clocks per sample.
+		assert(t, bl.ClocksNeeded(10), 200)
+		assert(t, bl.ClocksNeeded(30), 400)
+		bl.EndFrame(400)
+		assert(t, bl.SamplesAvailable(), 30)
+
+		bl.SetRatesAt(100, 1000, 100)
+		shouldPanic(t, func() { bl.SetRatesAt(50, 1000, 100) })
+		shouldPanic(t, func() { bl.EndFrame(50) })
+	})
+}
+
+func TestResize(t *testing.T) {
+	const frameLen = 30 * oversample
+
+	frame := func(bl *Buffer, f int) {
+		bl.AddDelta(uint64(f*oversample/3), int32(2000*(f%3)-2000))
+		bl.AddDelta(frameLen/2, 1000)
+		bl.AddDelta(frameLen+oversample, -1000) // past the end of frame
+		bl.EndFrame(frameLen)
+	}
+
+	want := NewBuffer(300)
+	bl := NewBuffer(50)
+	for f := range 10 {
+		if f == 1 {
+			bl.Resize(300)
+		}
+		frame(want, f)
+		frame(bl, f)
+		if f == 8 {
+			// Deltas past available samples are preserved too.
+			bl.AddDelta(oversample, 500)
+			want.AddDelta(oversample, 500)
+		}
+	}
+	wantbuf := make([]int16, 300)
+	want.ReadSamples(wantbuf, 300, Mono)
+
+	bl.Resize(bl.SamplesAvailable())
+	got := make([]int16, 300)
+	assert(t, bl.ReadSamples(got, 300, Mono), 300)
+	if diff := cmp.Diff(got, wantbuf); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+
+	bl.EndFrame(frameLen)
+	want.EndFrame(frameLen)
+	assert(t, bl.ReadSamples(got, 30, Mono), 30)
+	want.ReadSamples(wantbuf, 30, Mono)
+	if diff := cmp.Diff(got[:30], wantbuf[:30]); diff != "" {
+		t.Errorf("samples after resize mismatch (-got +want):\n%s", diff)
+	}
+
+	bl = NewBuffer(100)
+	bl.EndFrame(frameLen)
+	shouldPanic(t, func() { bl.Resize(29) })
+	bl.AddDelta(frameLen, 1000)
+	shouldPanic(t, func() { bl.Resize(30) })
+	bl.Resize(60)
+}
+
 func TestAddDelta(t *testing.T) {
 	const blipSize = MaxFrame / 2
 
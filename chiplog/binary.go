@@ -0,0 +1,136 @@
+package chiplog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// binaryMagic starts every binary log, the last byte being the format version.
+var binaryMagic = [4]byte{'B', 'C', 'L', 1}
+
+// ErrBinaryFormat is returned when reading data that isn't a binary log.
+var ErrBinaryFormat = errors.New("chiplog: invalid binary log")
+
+// BinaryWriter writes events to a binary log.
+//
+// A binary log starts with the 4 bytes "BCL\x01", followed by events. Each
+// event is a kind byte (0 for RegWrite, 1 for FrameEnd) followed by its
+// fields encoded as signed varints: time, then channel, address and data for
+// register writes.
+type BinaryWriter struct {
+	w      *bufio.Writer
+	header bool // whether the magic has been written
+	buf    []byte
+}
+
+// NewBinaryWriter returns a BinaryWriter writing a binary log to w. Writes are
+// buffered, Flush must be called once done.
+func NewBinaryWriter(w io.Writer) *BinaryWriter {
+	return &BinaryWriter{w: bufio.NewWriter(w)}
+}
+
+// Write writes a single event.
+func (w *BinaryWriter) Write(e Event) error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+
+	b := append(w.buf[:0], byte(e.Kind))
+	switch e.Kind {
+	case RegWrite:
+		b = binary.AppendVarint(b, int64(e.Time))
+		b = binary.AppendVarint(b, int64(e.Chan))
+		b = binary.AppendVarint(b, int64(e.Addr))
+		b = binary.AppendVarint(b, int64(e.Data))
+	case FrameEnd:
+		b = binary.AppendVarint(b, int64(e.Time))
+	default:
+		return fmt.Errorf("chiplog: unknown event kind %v", e.Kind)
+	}
+	w.buf = b
+	_, err := w.w.Write(b)
+	return err
+}
+
+// Flush writes any buffered data to the underlying writer. An empty log
+// still gets its header written.
+func (w *BinaryWriter) Flush() error {
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+func (w *BinaryWriter) writeHeader() error {
+	if w.header {
+		return nil
+	}
+	if _, err := w.w.Write(binaryMagic[:]); err != nil {
+		return err
+	}
+	w.header = true
+	return nil
+}
+
+// BinaryReader reads events from a binary log.
+type BinaryReader struct {
+	r      *bufio.Reader
+	header bool // whether the magic has been read
+	n      int  // number of events read
+}
+
+// NewBinaryReader returns a BinaryReader reading a binary log from r.
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{r: bufio.NewReader(r)}
+}
+
+// Read reads the next event. It returns io.EOF when there are no more events.
+// Errors in the log report the index of the faulty event.
+func (r *BinaryReader) Read() (Event, error) {
+	if !r.header {
+		var magic [4]byte
+		if _, err := io.ReadFull(r.r, magic[:]); err != nil || magic != binaryMagic {
+			return Event{}, ErrBinaryFormat
+		}
+		r.header = true
+	}
+
+	kind, err := r.r.ReadByte()
+	if err != nil {
+		return Event{}, err // io.EOF at the end of the log
+	}
+
+	var v [4]int
+	nfields := 1
+	switch Kind(kind) {
+	case RegWrite:
+		nfields = 4
+	case FrameEnd:
+	default:
+		return Event{}, r.errorf("unknown event kind %d", kind)
+	}
+
+	for i := range nfields {
+		n, err := binary.ReadVarint(r.r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return Event{}, r.errorf("%w", err)
+		}
+		v[i] = int(n)
+	}
+	r.n++
+
+	if Kind(kind) == FrameEnd {
+		return EndEvent(v[0]), nil
+	}
+	return WriteEvent(v[0], v[1], v[2], v[3]), nil
+}
+
+func (r *BinaryReader) errorf(format string, args ...any) error {
+	return fmt.Errorf("chiplog: event %d: "+format, append([]any{r.n}, args...)...)
+}
@@ -0,0 +1,115 @@
+// Package chiplog reads and writes logs of sound chip register writes.
+//
+// A log is a sequence of events, each one being either a register write or the
+// end of a time frame. Event times are clocks relative to the beginning of the
+// current time frame, just like the times passed to blip.Buffer.
+//
+// Logs come in 2 forms. The text form has one event per line, made of 4
+// integers separated by spaces: time, channel, register address and data. A
+// channel greater than or equal to FrameEndChan marks the end of a time frame,
+// the event time being the frame length:
+//
+//	6581 1 1 4
+//	6764 1 0 122
+//	29780 4 0 0
+//
+// The binary form holds the same events in a compact encoding, see
+// BinaryWriter.
+package chiplog
+
+import (
+	"fmt"
+	"io"
+)
+
+// FrameEndChan is the lowest channel number that marks the end of a time
+// frame in text logs. Writers always use FrameEndChan itself.
+const FrameEndChan = 4
+
+// Kind is the kind of an Event.
+type Kind uint8
+
+const (
+	// RegWrite is a write of Data to register Addr of channel Chan.
+	RegWrite Kind = iota
+
+	// FrameEnd ends the current time frame, Time being the frame length.
+	FrameEnd
+)
+
+func (k Kind) String() string {
+	switch k {
+	case RegWrite:
+		return "RegWrite"
+	case FrameEnd:
+		return "FrameEnd"
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// An Event is a single entry of a chip log.
+type Event struct {
+	Kind Kind
+	Time int // clock time in the current frame, or frame length for FrameEnd
+
+	// Only meaningful for RegWrite events.
+	Chan int
+	Addr int
+	Data int
+}
+
+// WriteEvent returns a register write event.
+func WriteEvent(time, ch, addr, data int) Event {
+	return Event{Kind: RegWrite, Time: time, Chan: ch, Addr: addr, Data: data}
+}
+
+// EndEvent returns an event ending a time frame of the given length.
+func EndEvent(length int) Event {
+	return Event{Kind: FrameEnd, Time: length}
+}
+
+// An EventReader reads events from a log. Read returns io.EOF when there are
+// no more events.
+type EventReader interface {
+	Read() (Event, error)
+}
+
+// An EventWriter writes events to a log. Flush must be called once done
+// writing events.
+type EventWriter interface {
+	Write(Event) error
+	Flush() error
+}
+
+// Copy copies all events from src to dst until io.EOF is reached, then flushes
+// dst. It returns the number of events copied.
+func Copy(dst EventWriter, src EventReader) (n int, err error) {
+	for {
+		e, err := src.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return n, err
+		}
+		if err := dst.Write(e); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, dst.Flush()
+}
+
+// TextToBinary converts a text log read from r to its binary form, written to
+// w.
+func TextToBinary(w io.Writer, r io.Reader) error {
+	_, err := Copy(NewBinaryWriter(w), NewReader(r))
+	return err
+}
+
+// BinaryToText converts a binary log read from r to its text form, written to
+// w.
+func BinaryToText(w io.Writer, r io.Reader) error {
+	_, err := Copy(NewWriter(w), NewBinaryReader(r))
+	return err
+}
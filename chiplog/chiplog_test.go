@@ -0,0 +1,156 @@
+package chiplog
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+const textLog = `0 3 2 24576
+6581 1 1 4
+
+6764 1 0 122
+29780 4 0 0
+6183 1 1 3
+29780 4 0 0
+`
+
+var wantEvents = []Event{
+	WriteEvent(0, 3, 2, 24576),
+	WriteEvent(6581, 1, 1, 4),
+	WriteEvent(6764, 1, 0, 122),
+	EndEvent(29780),
+	WriteEvent(6183, 1, 1, 3),
+	EndEvent(29780),
+}
+
+func readAll(t *testing.T, r EventReader) []Event {
+	t.Helper()
+
+	var events []Event
+	for {
+		e, err := r.Read()
+		if err == io.EOF {
+			return events
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		events = append(events, e)
+	}
+}
+
+func TestReader(t *testing.T) {
+	got := readAll(t, NewReader(strings.NewReader(textLog)))
+	if diff := cmp.Diff(got, wantEvents); diff != "" {
+		t.Errorf("events mismatch (-got +want):\n%s", diff)
+	}
+
+	// Any channel past the last one ends the frame.
+	got = readAll(t, NewReader(strings.NewReader("100 5 1 2\n")))
+	if diff := cmp.Diff(got, []Event{EndEvent(100)}); diff != "" {
+		t.Errorf("events mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		log  string
+		line int
+		err  error
+	}{
+		{log: "0 1 2 3\n1 2 3\n", line: 2, err: ErrFieldCount},
+		{log: "0 1 2 3\n\n\n1 2 x 4\n", line: 4},
+	}
+	for _, tt := range tests {
+		r := NewReader(strings.NewReader(tt.log))
+		var err error
+		for err == nil {
+			_, err = r.Read()
+		}
+
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("err = %v, want a ParseError", err)
+		}
+		if perr.Line != tt.line {
+			t.Errorf("error at line %d, want %d", perr.Line, tt.line)
+		}
+		if tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("err = %v, want %v", err, tt.err)
+		}
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, e := range wantEvents {
+		if err := w.Write(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.ReplaceAll(textLog, "\n\n", "\n")
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+
+	for _, ch := range []int{FrameEndChan, FrameEndChan + 1} {
+		if err := w.Write(WriteEvent(0, ch, 0, 0)); err != ErrChannel {
+			t.Errorf("channel %d: err = %v, want ErrChannel", ch, err)
+		}
+	}
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	var bin bytes.Buffer
+	if err := TextToBinary(&bin, strings.NewReader(textLog)); err != nil {
+		t.Fatal(err)
+	}
+	if bin.Len() >= len(textLog) {
+		t.Errorf("binary log is %d bytes, text log is %d", bin.Len(), len(textLog))
+	}
+
+	got := readAll(t, NewBinaryReader(bytes.NewReader(bin.Bytes())))
+	if diff := cmp.Diff(got, wantEvents); diff != "" {
+		t.Errorf("events mismatch (-got +want):\n%s", diff)
+	}
+
+	var text bytes.Buffer
+	if err := BinaryToText(&text, &bin); err != nil {
+		t.Fatal(err)
+	}
+	if want := strings.ReplaceAll(textLog, "\n\n", "\n"); text.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", text.String(), want)
+	}
+}
+
+func TestBinaryErrors(t *testing.T) {
+	var bin bytes.Buffer
+	w := NewBinaryWriter(&bin)
+	w.Write(EndEvent(100))
+	w.Write(WriteEvent(1, 2, 3, 4))
+	w.Flush()
+
+	// Truncate the last event.
+	r := NewBinaryReader(bytes.NewReader(bin.Bytes()[:bin.Len()-1]))
+	if _, err := r.Read(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	r = NewBinaryReader(strings.NewReader("0 1 2 3\n"))
+	if _, err := r.Read(); err != ErrBinaryFormat {
+		t.Errorf("err = %v, want ErrBinaryFormat", err)
+	}
+}
@@ -0,0 +1,118 @@
+package chiplog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A ParseError is returned when a text log line can't be parsed.
+type ParseError struct {
+	Line int // 1-based line number
+	Err  error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("chiplog: line %d: %v", e.Line, e.Err)
+}
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+var (
+	// ErrFieldCount is wrapped by a ParseError when a line doesn't have 4
+	// fields.
+	ErrFieldCount = errors.New("wrong number of fields")
+
+	// ErrChannel is returned when writing a register write event to one of
+	// the channels reserved to frame ends.
+	ErrChannel = errors.New("chiplog: register write to frame end channel")
+)
+
+// Reader reads events from a text log. Empty lines are ignored.
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewReader returns a Reader reading a text log from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{s: bufio.NewScanner(r)}
+}
+
+// Read reads the next event. It returns io.EOF when there are no more events
+// and a *ParseError if the line is malformed.
+func (r *Reader) Read() (Event, error) {
+	for r.s.Scan() {
+		r.line++
+		line := strings.TrimSpace(r.s.Text())
+		if line == "" {
+			continue
+		}
+		return r.parse(line)
+	}
+	if err := r.s.Err(); err != nil {
+		return Event{}, err
+	}
+	return Event{}, io.EOF
+}
+
+// Line returns the line number of the last event read.
+func (r *Reader) Line() int {
+	return r.line
+}
+
+func (r *Reader) parse(line string) (Event, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 4 {
+		return Event{}, &ParseError{Line: r.line, Err: ErrFieldCount}
+	}
+
+	var v [4]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return Event{}, &ParseError{Line: r.line, Err: err}
+		}
+		v[i] = n
+	}
+
+	if v[1] >= FrameEndChan {
+		return EndEvent(v[0]), nil
+	}
+	return WriteEvent(v[0], v[1], v[2], v[3]), nil
+}
+
+// Writer writes events to a text log. Writes are buffered, Flush must be
+// called once done.
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer writing a text log to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Write writes a single event.
+func (w *Writer) Write(e Event) error {
+	switch e.Kind {
+	case RegWrite:
+		if e.Chan >= FrameEndChan {
+			return ErrChannel
+		}
+		_, err := fmt.Fprintf(w.w, "%d %d %d %d\n", e.Time, e.Chan, e.Addr, e.Data)
+		return err
+	case FrameEnd:
+		_, err := fmt.Fprintf(w.w, "%d %d 0 0\n", e.Time, FrameEndChan)
+		return err
+	}
+	return fmt.Errorf("chiplog: unknown event kind %v", e.Kind)
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
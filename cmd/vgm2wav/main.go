@@ -0,0 +1,99 @@
+// Command vgm2wav renders a VGM or VGZ file to a wave file. An AIFF or FLAC
+// file is written instead if the output file has an .aif, .aiff, .aifc or
+// .flac extension.
+//
+// Usage:
+//
+//	vgm2wav [flags] file.vgm
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/arl/blip"
+	"github.com/arl/blip/audiofile"
+	"github.com/arl/blip/vgm"
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("vgm2wav: ")
+
+	out := flag.String("o", "", "output wave, AIFF or FLAC file (default: input file with .wav extension)")
+	rate := flag.Int("rate", 44100, "output sample rate")
+	loops := flag.Int("loops", 1, "number of times the looping part is repeated")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: vgm2wav [flags] file.vgm\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	in := flag.Arg(0)
+	if *out == "" {
+		*out = strings.TrimSuffix(in, filepath.Ext(in)) + ".wav"
+	}
+
+	if err := convert(in, *out, *rate, *loops); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func convert(in, out string, rate, loops int) error {
+	f, err := vgm.Open(in)
+	if err != nil {
+		return err
+	}
+	if t := f.Tag; t != nil {
+		log.Printf("%s - %s (%s)", t.Game, t.Track, t.Author)
+	}
+
+	// Play 1/100 s per frame.
+	const frameLen = vgm.SampleRate / 100
+
+	bl := blip.NewBuffer(rate / 10)
+	p, err := vgm.NewPlayer(f, bl)
+	if err != nil {
+		return err
+	}
+	p.SetLoops(loops)
+	bl.SetRates(p.ClockRate(), float64(rate))
+
+	w, err := audiofile.Create(out, rate)
+	if err != nil {
+		return err
+	}
+
+	buf := make([]int16, 1024)
+	for {
+		_, perr := p.Play(frameLen)
+		if perr != nil && !errors.Is(perr, io.EOF) {
+			w.Close()
+			return perr
+		}
+
+		for bl.SamplesAvailable() > 0 {
+			n := bl.ReadSamples(buf, len(buf), blip.Mono)
+			if _, err := w.Write(buf[:n]); err != nil {
+				w.Close()
+				return err
+			}
+		}
+
+		if perr != nil {
+			break
+		}
+	}
+	return w.Close()
+}
@@ -0,0 +1,137 @@
+package blip
+
+import (
+	"cmp"
+	"math/bits"
+	"slices"
+)
+
+// deltaKind is the synthesis of a queued delta.
+type deltaKind uint8
+
+const (
+	stepDelta    deltaKind = iota // added with AddDelta
+	fastDelta                     // added with AddDeltaFast
+	impulseDelta                  // added with AddImpulse
+)
+
+// queuedDelta is a delta waiting to be rendered by a Buffer in deferred mode.
+type queuedDelta struct {
+	pos   uint64 // absolute position, in units of 1<<preShift time units
+	delta int32
+	kind  deltaKind
+}
+
+// SetDeferred sets the buffer in deferred mode, where time frames can be
+// longer than the buffer.
+//
+// In deferred mode, deltas are queued rather than added to the buffer, and
+// rendered as samples are read. Time frames can then last as long as needed,
+// for example a whole second when rendering a logged song as fast as
+// possible, SamplesAvailable possibly exceeding the buffer size. At most
+// the buffer size samples are read at once. SetRatesAt and AddSlope aren't
+// supported in deferred mode.
+//
+// Deferred mode can be left once all queued deltas fit in the buffer, it
+// panics otherwise.
+func (b *Buffer) SetDeferred(deferred bool) {
+	if deferred && b.ramp != nil {
+		panic("slopes aren't supported in deferred mode")
+	}
+	if !deferred && b.deferred {
+		b.flush()
+		if len(b.pending) > 0 || b.avail > b.size {
+			panic("queued deltas don't fit in buffer")
+		}
+	}
+	b.deferred = deferred
+}
+
+// pos128 returns time*factor+offset as a 128-bit number, time having frac
+// fraction bits.
+func (b *Buffer) pos128(time uint64, frac uint) (hi, lo uint64) {
+	hi, lo = bits.Mul64(time, b.factor)
+	if frac > 0 {
+		lo = lo>>frac | hi<<(64-frac)
+		hi >>= frac
+	}
+	var carry uint64
+	lo, carry = bits.Add64(lo, b.offset, 0)
+	return hi + carry, lo
+}
+
+// queue queues a delta at the given clock time, having frac fraction bits.
+func (b *Buffer) queue(time uint64, frac uint, delta int32, kind deltaKind) {
+	hi, lo := b.pos128(time, frac)
+	fixed := hi<<(64-preShift) | lo>>preShift
+	pos := b.produced<<fracBits + fixed
+	if n := len(b.pending); n > 0 && pos < b.pending[n-1].pos {
+		b.unsorted = true
+	}
+	b.pending = append(b.pending, queuedDelta{pos: pos, delta: delta, kind: kind})
+}
+
+// endFrameDeferred ends a time frame of the given clock duration, having frac
+// fraction bits.
+func (b *Buffer) endFrameDeferred(clockDuration uint64, frac uint) {
+	hi, lo := b.pos128(clockDuration, frac)
+	count := hi<<(64-timeBits) | lo>>timeBits
+	b.advance(int(count), lo&(timeUnit-1), clockDuration<<(FracBits-frac))
+}
+
+// clocksNeededDeferred returns the clock time, having frac fraction bits,
+// needed to make nsamples additional samples available.
+func (b *Buffer) clocksNeededDeferred(nsamples int, frac uint) uint64 {
+	if nsamples < 0 {
+		panic("buffer can't hold that many samples")
+	}
+
+	// ceil((nsamples*timeUnit-offset) << frac / factor), in 128 bits.
+	n := uint64(nsamples)
+	hi, lo := n>>(64-timeBits), n<<timeBits
+	if hi == 0 && lo < b.offset {
+		return 0
+	}
+	var borrow, carry uint64
+	lo, borrow = bits.Sub64(lo, b.offset, 0)
+	hi -= borrow
+	if frac > 0 {
+		hi = hi<<frac | lo>>(64-frac)
+		lo <<= frac
+	}
+	lo, carry = bits.Add64(lo, b.factor-1, 0)
+	q, _ := bits.Div64(hi+carry, lo, b.factor)
+	return q
+}
+
+// flush renders the queued deltas that fit in the buffer.
+func (b *Buffer) flush() {
+	if len(b.pending) == 0 {
+		return
+	}
+	if b.unsorted {
+		slices.SortFunc(b.pending, func(a, c queuedDelta) int {
+			return cmp.Compare(a.pos, c.pos)
+		})
+		b.unsorted = false
+	}
+
+	base := (b.produced - uint64(b.avail)) << fracBits
+	n := 0
+	for _, p := range b.pending {
+		fixed := p.pos - base
+		if fixed>>fracBits > uint64(b.size)+endFrameExtra {
+			break
+		}
+		switch p.kind {
+		case stepDelta:
+			b.render(fixed, p.delta)
+		case fastDelta:
+			b.renderFast(fixed, p.delta)
+		case impulseDelta:
+			b.renderImpulse(fixed, p.delta)
+		}
+		n++
+	}
+	b.pending = b.pending[n:]
+}
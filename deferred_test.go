@@ -0,0 +1,100 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// deferredDelta is a delta at an absolute clock time.
+type deferredDelta struct {
+	time  uint64
+	delta int32
+	fast  bool
+}
+
+// renderDeltas renders deltas, sorted by time, in time frames of frameLen
+// clocks, reading samples in chunks of at most 300 samples.
+func renderDeltas(bl *Buffer, deltas []deferredDelta, frameLen, frames uint64) []int16 {
+	var out []int16
+	buf := make([]int16, 300)
+	for f := range frames {
+		start := f * frameLen
+		for _, d := range deltas {
+			if d.time < start || d.time >= start+frameLen {
+				continue
+			}
+			if d.fast {
+				bl.AddDeltaFast(d.time-start, d.delta)
+			} else {
+				bl.AddDelta(d.time-start, d.delta)
+			}
+		}
+		bl.EndFrame(int(frameLen))
+		for bl.SamplesAvailable() > 0 {
+			n := bl.ReadSamples(buf, len(buf), Mono)
+			out = append(out, buf[:n]...)
+		}
+	}
+	return out
+}
+
+func TestDeferred(t *testing.T) {
+	const (
+		clockRate = 3579545
+		second    = clockRate
+	)
+
+	// Two square waves, deltas of both being interleaved out of time order.
+	var deltas []deferredDelta
+	for i := range uint64(second / 4000) {
+		deltas = append(deltas,
+			deferredDelta{time: i * 4000, delta: int32(2000 * (1 - 2*int(i%2)))},
+			deferredDelta{time: i*4000 - i*4000%3001, delta: int32(1000 * (1 - 2*int(i%3%2))), fast: true},
+		)
+	}
+
+	want := NewBuffer(1000)
+	want.SetRates(clockRate, 44100)
+	wantOut := renderDeltas(want, deltas, second/100, 100)
+
+	bl := NewBuffer(1000)
+	bl.SetRates(clockRate, 44100)
+	bl.SetDeferred(true)
+	got := renderDeltas(bl, deltas, second/100*100, 1)
+	if diff := cmp.Diff(got, wantOut); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+	if len(got) < 44000 {
+		t.Errorf("got %d samples, want a second of samples", len(got))
+	}
+
+	// Back to normal mode, ClocksNeeded is limited by the buffer size.
+	bl.SetDeferred(false)
+	shouldPanic(t, func() { bl.ClocksNeeded(1001) })
+}
+
+func TestDeferredClocksNeeded(t *testing.T) {
+	bl := NewBuffer(100)
+	bl.SetRates(1789773, 44100)
+	bl.SetDeferred(true)
+
+	for _, n := range []int{1, 100, 44100, 1000000} {
+		bl.Clear()
+		clocks := bl.ClocksNeeded(n)
+		bl.EndFrame(clocks - 1)
+		assert(t, bl.SamplesAvailable(), n-1)
+
+		bl.Clear()
+		bl.EndFrame(clocks)
+		assert(t, bl.SamplesAvailable(), n)
+
+		bl.Clear()
+		bl.EndFrameFrac(bl.ClocksNeededFrac(n))
+		assert(t, bl.SamplesAvailable(), n)
+	}
+
+	// Pending samples don't fit in the buffer.
+	shouldPanic(t, func() { bl.SetDeferred(false) })
+	shouldPanic(t, func() { bl.SetRatesAt(10, 1789773, 48000) })
+}
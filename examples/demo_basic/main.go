@@ -6,7 +6,7 @@ import (
 	"os"
 
 	"github.com/arl/blip"
-	"github.com/arl/blip/wave"
+	"github.com/arl/blip/sink"
 )
 
 const sampleRate = 44100             // 44.1 kHz sample rate
@@ -29,7 +29,11 @@ func runWave(bl *blip.Buffer, clocks int) {
 	}
 }
 
-func flushSamples(bl *blip.Buffer, wv *wave.Writer) {
+// flushSamples writes available samples to s and returns the number of
+// samples written.
+func flushSamples(bl *blip.Buffer, s sink.Sink) int {
+	written := 0
+
 	// If we only wanted 512-sample chunks, never smaller, we would
 	// do >= 512 instead of > 0. Any remaining samples would be left
 	// in buffer for next time.
@@ -39,8 +43,10 @@ func flushSamples(bl *blip.Buffer, wv *wave.Writer) {
 		// count is number of samples actually read (in case there
 		// were fewer than temp_size samples actually available)
 		count := bl.ReadSamples(temp, len(temp), blip.Mono)
-		wv.Write(temp[:count])
+		s.WriteFrames(temp[:count])
+		written += count
 	}
+	return written
 }
 
 func main() {
@@ -54,10 +60,10 @@ func main() {
 	}
 	defer f.Close()
 
-	wv := wave.NewWriter(f, sampleRate)
-	defer wv.Close()
+	s := sink.NewWave(f, sink.Format{SampleRate: sampleRate, Channels: 1})
+	defer s.Close()
 
-	for wv.SampleCount() < 2*sampleRate {
+	for written := 0; written < 2*sampleRate; {
 		// Generate 1/60 second each time through loop
 		fclocks := clockRate / 60.0
 		clocks := int(fclocks)
@@ -66,7 +72,7 @@ func main() {
 		bl.EndFrame(clocks)
 		time -= clocks // adjust for new time frame
 
-		flushSamples(bl, wv)
+		written += flushSamples(bl, s)
 
 		// Slowly increase volume and lower
 		volume += 100
@@ -3,18 +3,20 @@ package main
 import (
 	"bytes"
 	_ "embed"
-	"fmt"
+	"io"
 	"log"
 
 	"github.com/arl/blip"
-	"github.com/arl/blip/wave"
+	"github.com/arl/blip/chiplog"
+	"github.com/arl/blip/sink"
 )
 
 const sampleRate = 44100      /* 44.1 kHz sample rate*/
 const clockRate = 1789772.727 /* 1.78 MHz clock rate */
 
 var bl *blip.Buffer
-var wv *wave.Writer
+var out sink.Sink
+var frames int // frames written to out
 
 // indices into regs
 const (
@@ -114,7 +116,8 @@ func endFrame(endTime int) {
 		// count is number of samples actually read (in case there
 		// were fewer than tempSize samples actually available)
 		count := bl.ReadSamples(temp[:], tempSize, blip.Mono)
-		wv.Write(temp[:count])
+		n, _ := out.WriteFrames(temp[:count])
+		frames += n
 	}
 }
 
@@ -126,27 +129,31 @@ func main() {
 	bl.SetRates(clockRate, sampleRate)
 
 	// Play back logged writes and record to wave sound file
-	in := bytes.NewReader(chipLog)
+	in := chiplog.NewReader(bytes.NewReader(chipLog))
 
 	var err error
-	wv, err = wave.NewFile("out.wav", sampleRate)
+	out, err = sink.Create("out.wav", sink.Format{SampleRate: sampleRate, Channels: 1})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	for wv.SampleCount() < 120*sampleRate {
+	for frames < 120*sampleRate {
 		// In an emulator these writes would be generated by the emulated CPU
-		var time, ch, addr, data int
-		if n, _ := fmt.Fscanf(in, "%d %d %d %d\n", &time, &ch, &addr, &data); n < 4 {
+		e, err := in.Read()
+		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			log.Fatal(err)
+		}
 
-		if ch < chanCount {
-			writeChannel(time, ch, addr, data)
-		} else {
-			endFrame(time)
+		switch e.Kind {
+		case chiplog.RegWrite:
+			writeChannel(e.Time, e.Chan, e.Addr, e.Data)
+		case chiplog.FrameEnd:
+			endFrame(e.Time)
 		}
 	}
 
-	wv.Close()
+	out.Close()
 }
@@ -4,7 +4,7 @@ import (
 	"log"
 
 	"github.com/arl/blip"
-	"github.com/arl/blip/wave"
+	"github.com/arl/blip/sink"
 )
 
 // Implements a simple square wave generator as might be used in an analog
@@ -12,10 +12,11 @@ import (
 
 const sampleRate = 44100
 
-// Use the maximum supported clock rate, which is about one million times the
-// sample rate, 46 GHz in this case. This gives all the accuracy needed, even
-// for extremely fine frequency control.
-const clockRate = sampleRate * blip.MaxRatio
+// Use a clock rate typical of sound chips, the NTSC color burst frequency.
+// Half periods are rarely whole numbers of clocks at this rate, so times are
+// kept in fractional clocks, with blip.FracBits fraction bits. This gives all
+// the accuracy needed, even for extremely fine frequency control.
+const clockRate = 3579545
 
 var bl *blip.Buffer
 
@@ -23,7 +24,7 @@ type wavebuf struct {
 	frequency float64 // cycles per second
 	volume    float64 // 0.0 to 1.0
 	phase     int     // +1 or -1
-	time      int     // clock time of next delta
+	time      uint64  // fractional clock time of next delta
 	amp       int     // current amplitude in delta buffer
 }
 
@@ -39,9 +40,9 @@ var waves = [2]wavebuf{
 	},
 }
 
-func (w *wavebuf) run(clocks int) {
-	// Clocks for each half of square wave cycle
-	period := int(clockRate/w.frequency/2 + 0.5)
+func (w *wavebuf) run(clocks uint64) {
+	// Fractional clocks for each half of square wave cycle
+	period := uint64(clockRate/w.frequency/2*blip.FracUnit + 0.5)
 
 	// Convert volume to 16-bit sample range (divided by 2 because it's bipolar)
 	volume := int(w.volume*65536/2 + 0.5)
@@ -50,7 +51,7 @@ func (w *wavebuf) run(clocks int) {
 	for ; w.time < clocks; w.time += period {
 		delta := w.phase*volume - w.amp
 		w.amp += delta
-		bl.AddDelta(uint64(w.time), int32(delta))
+		bl.AddDeltaFrac(w.time, int32(delta))
 		w.phase = -w.phase
 	}
 
@@ -59,11 +60,11 @@ func (w *wavebuf) run(clocks int) {
 
 // Generates enough samples to exactly fill out
 func genSamples(out []int16) {
-	clocks := bl.ClocksNeeded(len(out))
+	clocks := bl.ClocksNeededFrac(len(out))
 	waves[0].run(clocks)
 	waves[1].run(clocks)
 
-	bl.EndFrame(clocks)
+	bl.EndFrameFrac(clocks)
 	bl.ReadSamples(out, len(out), blip.Mono)
 }
 
@@ -71,7 +72,7 @@ func main() {
 	bl = blip.NewBuffer(sampleRate / 10)
 	bl.SetRates(clockRate, sampleRate)
 
-	w, err := wave.NewFile("out.wav", sampleRate)
+	s, err := sink.Create("out.wav", sink.Format{SampleRate: sampleRate, Channels: 1})
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -79,9 +80,10 @@ func main() {
 	const samples = 1024
 	var temp [samples]int16
 
-	for w.SampleCount() < 2*sampleRate*2 {
+	for frames := 0; frames < 2*sampleRate*2; {
 		genSamples(temp[:])
-		w.Write(temp[:samples])
+		n, _ := s.WriteFrames(temp[:samples])
+		frames += n
 
 		// Slowly increase volume and lower pitch
 		waves[0].volume += 0.005
@@ -91,5 +93,5 @@ func main() {
 		waves[1].volume -= 0.002
 		waves[1].frequency *= 1.010
 	}
-	w.Close()
+	s.Close()
 }
@@ -6,7 +6,7 @@ import (
 	"log"
 
 	"github.com/arl/blip"
-	"github.com/arl/blip/wave"
+	"github.com/arl/blip/sink"
 )
 
 const sampleRate = 44100
@@ -74,18 +74,18 @@ func initSound() {
 func main() {
 	initSound()
 
-	w, err := wave.NewFile("out.wav", sampleRate)
+	s, err := sink.Create("out.wav", sink.Format{SampleRate: sampleRate, Channels: 2})
 	if err != nil {
 		log.Fatal(err)
 	}
-	w.EnableStereo()
 
 	const samples = 2048
 	var temp [samples]int16
 
-	for w.SampleCount() < 2*sampleRate*2 {
+	for frames := 0; frames < 2*sampleRate; {
 		genSamples(temp[:])
-		w.Write(temp[:samples])
+		n, _ := s.WriteFrames(temp[:samples])
+		frames += n
 
 		// Slowly increase volume and lower pitch
 		waves[0].volume += 0.005
@@ -95,5 +95,5 @@ func main() {
 		waves[1].volume -= 0.002
 		waves[1].frequency *= 1.010
 	}
-	w.Close()
+	s.Close()
 }
@@ -0,0 +1,181 @@
+package flac
+
+import (
+	"bufio"
+	"math/bits"
+)
+
+// bitWriter appends bits to a byte slice, most significant bit first.
+type bitWriter struct {
+	b   []byte
+	acc uint64
+	n   uint // bits pending in acc
+}
+
+// writeBits writes the n low bits of v, n <= 32.
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.n += n
+	for w.n >= 8 {
+		w.n -= 8
+		w.b = append(w.b, byte(w.acc>>w.n))
+	}
+}
+
+// writeSigned writes the n low bits of the two's complement of v.
+func (w *bitWriter) writeSigned(v int32, n uint) {
+	w.writeBits(uint64(uint32(v)), n)
+}
+
+// writeRice writes u with the Rice parameter k: the quotient u>>k in unary,
+// as zeros ended by a one, followed by the k low bits of u.
+func (w *bitWriter) writeRice(u uint32, k uint) {
+	q := u >> k
+	for ; q >= 32; q -= 32 {
+		w.writeBits(0, 32)
+	}
+	w.writeBits(0, uint(q))
+	w.writeBits(1<<k|uint64(u), k+1)
+}
+
+// align pads with zeros up to the next byte boundary.
+func (w *bitWriter) align() {
+	if w.n > 0 {
+		w.writeBits(0, 8-w.n)
+	}
+}
+
+// bitReader reads bits from a byte stream, most significant bit first. It
+// keeps the CRC-8 and CRC-16 of the bytes read since the last call to
+// resetCRC.
+type bitReader struct {
+	r     *bufio.Reader
+	acc   uint64
+	n     uint // bits pending in acc
+	crc8  uint8
+	crc16 uint16
+}
+
+func (r *bitReader) fill() error {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return err
+	}
+	r.crc8 = crc8Table[r.crc8^c]
+	r.crc16 = r.crc16<<8 ^ crc16Table[byte(r.crc16>>8)^c]
+	r.acc = r.acc<<8 | uint64(c)
+	r.n += 8
+	return nil
+}
+
+func (r *bitReader) resetCRC() {
+	r.crc8, r.crc16 = 0, 0
+}
+
+// readBits reads n bits, n <= 56.
+func (r *bitReader) readBits(n uint) (uint64, error) {
+	for r.n < n {
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+	r.n -= n
+	return r.acc >> r.n & (1<<n - 1), nil
+}
+
+// readSigned reads an n-bit two's complement integer.
+func (r *bitReader) readSigned(n uint) (int64, error) {
+	v, err := r.readBits(n)
+	if err != nil || n == 0 {
+		return 0, err
+	}
+	return int64(v<<(64-n)) >> (64 - n), nil
+}
+
+// readUnary reads zeros up to the next one, and returns how many there were.
+func (r *bitReader) readUnary() (uint32, error) {
+	var q uint32
+	for {
+		if r.n == 0 {
+			if err := r.fill(); err != nil {
+				return 0, err
+			}
+		}
+		v := r.acc & (1<<r.n - 1)
+		if v == 0 {
+			q += uint32(r.n)
+			r.n = 0
+			continue
+		}
+		l := uint(bits.Len64(v))
+		q += uint32(r.n - l)
+		r.n = l - 1
+		return q, nil
+	}
+}
+
+// readRice reads a Rice coded value with parameter k and undoes its zigzag
+// encoding.
+func (r *bitReader) readRice(k uint) (int32, error) {
+	q, err := r.readUnary()
+	if err != nil {
+		return 0, err
+	}
+	low, err := r.readBits(k)
+	if err != nil {
+		return 0, err
+	}
+	u := q<<k | uint32(low)
+	return int32(u>>1) ^ -int32(u&1), nil
+}
+
+// align discards bits up to the next byte boundary.
+func (r *bitReader) align() {
+	r.n -= r.n % 8
+}
+
+// zigzag maps signed residuals to unsigned values, 0, -1, 1, -2, 2... to 0,
+// 1, 2, 3, 4...
+func zigzag(v int32) uint32 {
+	return uint32(v<<1) ^ uint32(v>>31)
+}
+
+var (
+	crc8Table  [256]uint8  // polynomial x^8 + x^2 + x + 1
+	crc16Table [256]uint16 // polynomial x^16 + x^15 + x^2 + 1
+)
+
+func init() {
+	for i := range 256 {
+		c8, c16 := uint8(i), uint16(i)<<8
+		for range 8 {
+			if c8&0x80 != 0 {
+				c8 = c8<<1 ^ 0x07
+			} else {
+				c8 <<= 1
+			}
+			if c16&0x8000 != 0 {
+				c16 = c16<<1 ^ 0x8005
+			} else {
+				c16 <<= 1
+			}
+		}
+		crc8Table[i], crc16Table[i] = c8, c16
+	}
+}
+
+func crc8(b []byte) uint8 {
+	var c uint8
+	for _, x := range b {
+		c = crc8Table[c^x]
+	}
+	return c
+}
+
+func crc16(b []byte) uint16 {
+	var c uint16
+	for _, x := range b {
+		c = c<<8 ^ crc16Table[byte(c>>8)^x]
+	}
+	return c
+}
@@ -0,0 +1,439 @@
+package flac
+
+import (
+	"math"
+	"math/bits"
+)
+
+// Subframe types.
+const (
+	subConstant = iota
+	subVerbatim
+	subFixed
+	subLPC
+)
+
+const (
+	maxFixedOrder     = 4
+	maxLPCOrder       = 12
+	maxPartitionOrder = 8
+
+	// lpcPrecision is the precision of quantized LPC coefficients, in bits.
+	// Predictions are computed with 64-bit integers, so the largest allowed
+	// precision can be used.
+	lpcPrecision = 15
+)
+
+// partition describes how a residual is split into Rice coded partitions.
+type partition struct {
+	order  int
+	params [1 << maxPartitionOrder]uint8
+	rice2  bool // 5-bit parameters
+}
+
+// subframe is the encoding chosen for a channel of a frame.
+type subframe struct {
+	kind  int
+	order int // predictor order
+	shift int // of LPC prediction
+	coefs [maxLPCOrder]int32
+	part  partition
+	bits  int // estimated size
+}
+
+// channel is a channel of a frame, with the encoding chosen for it.
+type channel struct {
+	x   []int32
+	bps int
+	sf  subframe
+	res []int32 // residual of fixed and LPC subframes
+}
+
+// encoder holds scratch buffers reused from frame to frame.
+type encoder struct {
+	res       []int32
+	chanRes   [4][]int32 // residuals of channels being compared
+	sums      [1 << maxPartitionOrder]uint64
+	window    []float64
+	wx        []float64
+	lpc       [maxLPCOrder][maxLPCOrder]float64
+	mid, side []int32
+}
+
+// encodeChannel picks the smallest encoding of the samples x, with bps bits
+// per sample. The residual is kept in buf, which is grown as needed.
+func (e *encoder) encodeChannel(x []int32, bps int, buf *[]int32) channel {
+	if cap(*buf) < len(x) {
+		*buf = make([]int32, len(x))
+	}
+	sf, res := e.analyze(x, bps, (*buf)[:len(x)])
+	return channel{x: x, bps: bps, sf: sf, res: res}
+}
+
+// analyze returns the smallest encoding of the samples x, and the residual
+// to write for fixed and LPC subframes, stored in dst.
+func (e *encoder) analyze(x []int32, bps int, dst []int32) (subframe, []int32) {
+	n := len(x)
+	if cap(e.res) < n {
+		e.res = make([]int32, n)
+	}
+
+	constant := true
+	for _, v := range x[1:] {
+		if v != x[0] {
+			constant = false
+			break
+		}
+	}
+	if constant {
+		return subframe{kind: subConstant, bits: 8 + bps}, nil
+	}
+
+	best := subframe{kind: subVerbatim, bits: 8 + n*bps}
+	var bestRes []int32
+	try := func(sf *subframe, res []int32) {
+		sf.bits += e.riceCost(res, n, sf.order, &sf.part)
+		if sf.bits < best.bits {
+			best = *sf
+			// Keep the residual, the scratch buffer is reused.
+			bestRes = dst[:len(res)]
+			copy(bestRes, res)
+		}
+	}
+
+	for order := 0; order <= min(maxFixedOrder, n-1); order++ {
+		res := fixedResidual(x, order, e.res[:n-order])
+		try(&subframe{kind: subFixed, order: order, bits: 8 + order*bps}, res)
+	}
+
+	maxOrder := min(maxLPCOrder, n-1)
+	if maxOrder > 0 && e.computeLPC(x, maxOrder) {
+		sf := subframe{kind: subLPC}
+		if e.lpcOrder(x, bps, maxOrder, &sf) {
+			order := sf.order
+			if res, ok := lpcResidual(x, sf.coefs[:order], sf.shift, e.res[:n-order]); ok {
+				sf.bits = 8 + order*bps + 4 + 5 + order*lpcPrecision
+				try(&sf, res)
+			}
+		}
+	}
+	return best, bestRes
+}
+
+// lpcOrder picks the LPC order up to maxOrder likely to give the smallest
+// subframe, and stores it with its quantized coefficients in sf. Orders are
+// compared on a subset of the residual, to save time. It reports false if
+// no order can be used.
+func (e *encoder) lpcOrder(x []int32, bps, maxOrder int, sf *subframe) bool {
+	const stride = 4
+
+	n := len(x)
+	bestBits := math.MaxInt
+	var coefs [maxLPCOrder]int32
+orders:
+	for order := 1; order <= maxOrder; order++ {
+		var shift int
+		if !quantize(e.lpc[order-1][:order], lpcPrecision, coefs[:order], &shift) {
+			continue
+		}
+		var sum uint64
+		for j := order; j < n; j += stride {
+			r, ok := lpcPredict(x, coefs[:order], shift, j)
+			if !ok {
+				continue orders
+			}
+			sum += uint64(zigzag(r))
+		}
+		count := (n - order + stride - 1) / stride
+		_, bits := riceParam(sum, count)
+		bits = bits*stride + order*(bps+lpcPrecision)
+		if bits < bestBits {
+			bestBits = bits
+			sf.order, sf.shift, sf.coefs = order, shift, coefs
+		}
+	}
+	return bestBits < math.MaxInt
+}
+
+// fixedResidual computes the residual of the fixed predictor of the given
+// order into res.
+func fixedResidual(x []int32, order int, res []int32) []int32 {
+	for i := range res {
+		j := i + order
+		switch order {
+		case 0:
+			res[i] = x[j]
+		case 1:
+			res[i] = x[j] - x[j-1]
+		case 2:
+			res[i] = x[j] - 2*x[j-1] + x[j-2]
+		case 3:
+			res[i] = x[j] - 3*x[j-1] + 3*x[j-2] - x[j-3]
+		case 4:
+			res[i] = x[j] - 4*x[j-1] + 6*x[j-2] - 4*x[j-3] + x[j-4]
+		}
+	}
+	return res
+}
+
+// computeLPC computes the linear prediction coefficients of x for all orders
+// up to maxOrder into e.lpc, using the autocorrelation of x windowed with a
+// Tukey window and the Levinson-Durbin recursion. It reports false if x
+// can't be predicted.
+func (e *encoder) computeLPC(x []int32, maxOrder int) bool {
+	n := len(x)
+	if len(e.window) != n {
+		e.window = tukey(n, 0.5)
+		e.wx = make([]float64, n)
+	}
+	for i, v := range x {
+		e.wx[i] = float64(v) * e.window[i]
+	}
+
+	var r [maxLPCOrder + 1]float64
+	for lag := range maxOrder + 1 {
+		var s float64
+		for i := lag; i < n; i++ {
+			s += e.wx[i] * e.wx[i-lag]
+		}
+		r[lag] = s
+	}
+	if r[0] == 0 {
+		return false
+	}
+
+	// a[j] is the coefficient of x[i-1-j] in the prediction of x[i].
+	var a, prev [maxLPCOrder]float64
+	err := r[0]
+	for m := range maxOrder {
+		k := r[m+1]
+		for j := range m {
+			k -= a[j] * r[m-j]
+		}
+		k /= err
+
+		prev = a
+		for j := range m {
+			a[j] = prev[j] - k*prev[m-1-j]
+		}
+		a[m] = k
+		err *= 1 - k*k
+		e.lpc[m] = a
+
+		if err <= 0 {
+			// Perfect prediction, higher orders are useless.
+			for i := m + 1; i < maxOrder; i++ {
+				e.lpc[i] = a
+			}
+			break
+		}
+	}
+	return true
+}
+
+// tukey returns a Tukey window of size n, tapering the given fraction of
+// samples with a cosine.
+func tukey(n int, p float64) []float64 {
+	w := make([]float64, n)
+	np := int(p / 2 * float64(n))
+	for i := range w {
+		w[i] = 1
+	}
+	for i := range np {
+		v := 0.5 - 0.5*math.Cos(math.Pi*float64(i)/float64(np))
+		w[i], w[n-1-i] = v, v
+	}
+	return w
+}
+
+// quantize quantizes the LPC coefficients lpc to signed integers of the given
+// precision in q, scaled by 2^shift. It reports false if lpc is all zeros.
+func quantize(lpc []float64, precision int, q []int32, shift *int) bool {
+	var cmax float64
+	for _, c := range lpc {
+		cmax = max(cmax, math.Abs(c))
+	}
+	if cmax == 0 {
+		return false
+	}
+
+	// The shift field is a 5-bit signed integer, negative shifts aren't
+	// allowed.
+	_, exp := math.Frexp(cmax)
+	*shift = min(max(precision-1-exp, 0), 15)
+
+	qmax := float64(int32(1)<<(precision-1) - 1)
+	var qerr float64
+	for i, c := range lpc {
+		// Carry the rounding error over to the next coefficient.
+		v := c*float64(int32(1)<<*shift) + qerr
+		qv := min(max(math.Round(v), -qmax-1), qmax)
+		qerr = v - qv
+		q[i] = int32(qv)
+	}
+	return true
+}
+
+// lpcResidual computes the residual of the LPC predictor into res. It reports
+// false if the residual is too large to be encoded.
+func lpcResidual(x, coefs []int32, shift int, res []int32) ([]int32, bool) {
+	order := len(coefs)
+	for i := range res {
+		r, ok := lpcPredict(x, coefs, shift, i+order)
+		if !ok {
+			return nil, false
+		}
+		res[i] = r
+	}
+	return res, true
+}
+
+// lpcPredict returns the residual of the LPC predictor for x[j]. It reports
+// false if the residual is too large to be encoded.
+func lpcPredict(x, coefs []int32, shift, j int) (int32, bool) {
+	var sum int64
+	for k, c := range coefs {
+		sum += int64(c) * int64(x[j-1-k])
+	}
+	r := int64(x[j]) - sum>>shift
+	if r < -1<<30 || r >= 1<<30 {
+		return 0, false
+	}
+	return int32(r), true
+}
+
+// riceCost estimates the size in bits of the residual res of a block of n
+// samples, predicted with the given order, when Rice coded. It stores the
+// partitioning that achieves it in p.
+func (e *encoder) riceCost(res []int32, n, order int, p *partition) int {
+	maxOrder := 0
+	for po := 1; po <= maxPartitionOrder; po++ {
+		if n%(1<<po) != 0 || n>>po <= order {
+			break
+		}
+		maxOrder = po
+	}
+
+	// Sum zigzag encoded residuals over the finest partitions.
+	size := n >> maxOrder
+	start := 0
+	for i := range 1 << maxOrder {
+		end := (i+1)*size - order
+		var s uint64
+		for _, r := range res[start:end] {
+			s += uint64(zigzag(r))
+		}
+		e.sums[i] = s
+		start = end
+	}
+
+	best := math.MaxInt
+	var params [1 << maxPartitionOrder]uint8
+	for po := maxOrder; po >= 0; po-- {
+		if po < maxOrder {
+			// Merge pairs of partitions.
+			for i := range 1 << po {
+				e.sums[i] = e.sums[2*i] + e.sums[2*i+1]
+			}
+		}
+
+		cost, rice2 := 2+4, false
+		for i := range 1 << po {
+			count := n >> po
+			if i == 0 {
+				count -= order
+			}
+			k, c := riceParam(e.sums[i], count)
+			params[i] = uint8(k)
+			rice2 = rice2 || k > 14
+			cost += c
+		}
+		if rice2 {
+			cost += 5 << po
+		} else {
+			cost += 4 << po
+		}
+
+		if cost < best {
+			best = cost
+			p.order = po
+			p.rice2 = rice2
+			copy(p.params[:1<<po], params[:1<<po])
+		}
+	}
+	return best
+}
+
+// riceParam returns the Rice parameter that best encodes count values whose
+// sum is s, and the estimated size in bits of the encoded values.
+func riceParam(s uint64, count int) (k, cost int) {
+	n := uint64(count)
+	if n == 0 {
+		return 0, 0
+	}
+	// The best parameter is close to log2 of the mean value.
+	k0 := bits.Len64(s / n)
+	cost = math.MaxInt
+	for c := max(k0-1, 0); c <= min(k0+1, 30); c++ {
+		if b := int(n*uint64(c+1) + s>>c); b < cost {
+			k, cost = c, b
+		}
+	}
+	return k, cost
+}
+
+// writeSubframe writes the encoded channel ch.
+func writeSubframe(w *bitWriter, ch *channel) {
+	x, bps, sf, res := ch.x, ch.bps, &ch.sf, ch.res
+	switch sf.kind {
+	case subConstant:
+		w.writeBits(0x00, 8)
+		w.writeSigned(x[0], uint(bps))
+	case subVerbatim:
+		w.writeBits(0x02, 8)
+		for _, v := range x {
+			w.writeSigned(v, uint(bps))
+		}
+	case subFixed:
+		w.writeBits(uint64(0x08|sf.order)<<1, 8)
+		for _, v := range x[:sf.order] {
+			w.writeSigned(v, uint(bps))
+		}
+		writeResidual(w, res, len(x), sf.order, &sf.part)
+	case subLPC:
+		w.writeBits(uint64(0x20|(sf.order-1))<<1, 8)
+		for _, v := range x[:sf.order] {
+			w.writeSigned(v, uint(bps))
+		}
+		w.writeBits(lpcPrecision-1, 4)
+		w.writeSigned(int32(sf.shift), 5)
+		for _, c := range sf.coefs[:sf.order] {
+			w.writeSigned(c, lpcPrecision)
+		}
+		writeResidual(w, res, len(x), sf.order, &sf.part)
+	}
+}
+
+// writeResidual writes the Rice coded residual res of a block of n samples
+// predicted with the given order.
+func writeResidual(w *bitWriter, res []int32, n, order int, p *partition) {
+	paramBits := uint(4)
+	if p.rice2 {
+		w.writeBits(1, 2)
+		paramBits = 5
+	} else {
+		w.writeBits(0, 2)
+	}
+	w.writeBits(uint64(p.order), 4)
+
+	start := 0
+	for i := range 1 << p.order {
+		end := (i+1)*(n>>p.order) - order
+		k := p.params[i]
+		w.writeBits(uint64(k), paramBits)
+		for _, r := range res[start:end] {
+			w.writeRice(zigzag(r), uint(k))
+		}
+		start = end
+	}
+}
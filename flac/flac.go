@@ -0,0 +1,385 @@
+// Package flac encodes and decodes FLAC (Free Lossless Audio Codec) files.
+//
+// The encoder accepts 16-bit samples, such as the ones produced by
+// blip.Buffer.ReadSamples, and picks the smallest of the verbatim, constant,
+// fixed and LPC encodings for every channel of every frame. Stereo files also
+// try the left/side, right/side and mid/side channel decorrelations.
+//
+// The decoder supports all FLAC files with up to 32 bits per sample, and
+// verifies frame CRCs and the MD5 signature of the decoded samples.
+package flac
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math"
+
+	"github.com/arl/blip/internal/patch"
+)
+
+// DefaultBlockSize is the number of samples per channel of each frame, unless
+// changed with SetBlockSize.
+const DefaultBlockSize = 4096
+
+// bitsPerSample is the sample size of encoded files.
+const bitsPerSample = 16
+
+// maxSampleRate is the maximum sample rate of a FLAC file.
+const maxSampleRate = 1<<20 - 1
+
+// sampleRates holds the sample rates for the frame header rate codes, 0 means
+// the rate isn't in the frame header.
+var sampleRates = [12]int{0, 88200, 176400, 192000, 8000, 16000, 22050, 24000, 32000, 44100, 48000, 96000}
+
+// ErrIncompleteFrame is returned by Close when the number of samples written
+// isn't a multiple of the number of channels.
+var ErrIncompleteFrame = errors.New("flac: incomplete sample frame")
+
+// A Writer encodes samples to a FLAC file.
+//
+// Samples are written as 16-bit, in frames of DefaultBlockSize samples per
+// channel. The number of channels and the block size can be changed with
+// SetChannels and SetBlockSize, before the first sample is written.
+//
+// The STREAMINFO header holds the number of samples and their MD5 signature,
+// which are only known when the Writer is closed. If the destination is an
+// io.WriteSeeker, such as the *os.File created by NewFile, frames are
+// streamed to it and the header is patched on Close. Otherwise, encoded
+// frames are buffered in memory until Close, where the header can be written
+// first.
+type Writer struct {
+	out         *patch.Writer
+	sampleRate  int
+	sampleCount int
+	chanCount   int
+	blockSize   int
+
+	frames             uint64 // number of frames written
+	minFrame, maxFrame int    // frame sizes, in bytes
+	md5                hash.Hash
+	pending            []int32   // interleaved samples of the next frame
+	chans              [][]int32 // deinterleaved samples of the current frame
+	raw                []byte    // little-endian samples of the current frame, for md5
+	enc                encoder
+	bw                 bitWriter
+}
+
+func newWriter(out *patch.Writer, sampleRate int) *Writer {
+	return &Writer{
+		out:        out,
+		sampleRate: sampleRate,
+		chanCount:  1,
+		blockSize:  DefaultBlockSize,
+		md5:        md5.New(),
+	}
+}
+
+// NewWriter creates a new Writer with the given sample rate, onto which samples
+// can be written with Write. Close must be called when done writing samples to
+// finalize the FLAC file. Close doesn't close w.
+func NewWriter(w io.Writer, sampleRate int) *Writer {
+	return newWriter(patch.New(w), sampleRate)
+}
+
+// NewFile creates a new FLAC file at the given path with the given sample
+// rate. Close must be called when done writing samples to finalize the file.
+func NewFile(path string, sampleRate int) (*Writer, error) {
+	out, err := patch.NewFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newWriter(out, sampleRate), nil
+}
+
+// EnableStereo sets the FLAC file to 2 interleaved channels.
+func (w *Writer) EnableStereo() {
+	w.SetChannels(2)
+}
+
+// SetChannels sets the number of interleaved channels, from 1 to 8. It panics
+// if called after samples have been written.
+func (w *Writer) SetChannels(n int) {
+	if n < 1 || n > 8 {
+		panic("flac: invalid channel count")
+	}
+	w.checkNotStarted()
+	w.chanCount = n
+}
+
+// SetBlockSize sets the number of samples per channel of each frame, from 16
+// to 65535. It panics if called after samples have been written.
+func (w *Writer) SetBlockSize(n int) {
+	if n < 16 || n > math.MaxUint16 {
+		panic("flac: invalid block size")
+	}
+	w.checkNotStarted()
+	w.blockSize = n
+}
+
+func (w *Writer) checkNotStarted() {
+	if w.out.Started() {
+		panic("flac: format changed after samples were written")
+	}
+}
+
+// SampleCount returns the number of samples written so far.
+func (w *Writer) SampleCount() int {
+	return w.sampleCount
+}
+
+func (w *Writer) header() []byte {
+	be := binary.BigEndian
+
+	h := make([]byte, 0, 42)
+	h = append(h, "fLaC"...)
+	h = append(h, 0x80, 0, 0, 34) // last metadata block, STREAMINFO, length
+	h = be.AppendUint16(h, uint16(w.blockSize))
+	h = be.AppendUint16(h, uint16(w.blockSize))
+	h = append(h, byte(w.minFrame>>16), byte(w.minFrame>>8), byte(w.minFrame))
+	h = append(h, byte(w.maxFrame>>16), byte(w.maxFrame>>8), byte(w.maxFrame))
+	h = be.AppendUint64(h, uint64(w.sampleRate)<<44|
+		uint64(w.chanCount-1)<<41|
+		uint64(bitsPerSample-1)<<36|
+		uint64(w.sampleCount/w.chanCount))
+	return w.md5.Sum(h)
+}
+
+// begin checks the format and begins the file.
+func (w *Writer) begin() error {
+	if w.sampleRate < 1 || w.sampleRate > maxSampleRate {
+		return fmt.Errorf("flac: invalid sample rate %d", w.sampleRate)
+	}
+	return w.out.Begin(w.header())
+}
+
+// Write writes 16-bit signed samples. Multichannel samples must be
+// interleaved.
+func (w *Writer) Write(p []int16) (n int, err error) {
+	return write(w, p, func(s int16) int16 { return s })
+}
+
+// WriteInt32 writes full scale 32-bit signed samples, truncated to 16 bits.
+// Multichannel samples must be interleaved.
+func (w *Writer) WriteInt32(p []int32) (n int, err error) {
+	return write(w, p, func(s int32) int16 { return int16(s >> 16) })
+}
+
+// WriteFloat32 writes samples in the [-1, 1] range, converted to 16 bits.
+// Values out of range are clamped. Multichannel samples must be interleaved.
+func (w *Writer) WriteFloat32(p []float32) (n int, err error) {
+	return write(w, p, func(s float32) int16 {
+		v := min(max(math.Round(float64(s)*(1<<31)), math.MinInt32), math.MaxInt32)
+		return int16(int32(v) >> 16)
+	})
+}
+
+// write converts samples with conv and encodes a frame each time a block is
+// complete.
+func write[T any](w *Writer, p []T, conv func(T) int16) (n int, err error) {
+	if !w.out.Started() {
+		if err := w.begin(); err != nil {
+			return 0, err
+		}
+	}
+
+	for _, s := range p {
+		w.pending = append(w.pending, int32(conv(s)))
+		n++
+		w.sampleCount++
+		if len(w.pending) == w.blockSize*w.chanCount {
+			if err := w.writeFrame(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// writeFrame encodes the pending samples to a frame.
+func (w *Writer) writeFrame() error {
+	frame := w.encodeFrame(w.pending)
+	w.pending = w.pending[:0]
+
+	if w.frames == 0 {
+		w.minFrame, w.maxFrame = len(frame), len(frame)
+	}
+	w.minFrame = min(w.minFrame, len(frame))
+	w.maxFrame = max(w.maxFrame, len(frame))
+	w.frames++
+
+	_, err := w.out.Write(frame)
+	return err
+}
+
+// encodeFrame returns the frame encoding the interleaved samples.
+func (w *Writer) encodeFrame(samples []int32) []byte {
+	n := len(samples) / w.chanCount
+	if len(w.chans) != w.chanCount {
+		w.chans = make([][]int32, w.chanCount)
+	}
+	w.raw = w.raw[:0]
+	for c := range w.chans {
+		if cap(w.chans[c]) < n {
+			w.chans[c] = make([]int32, n)
+		}
+		w.chans[c] = w.chans[c][:n]
+	}
+	for i, s := range samples {
+		w.chans[i%w.chanCount][i/w.chanCount] = s
+		w.raw = binary.LittleEndian.AppendUint16(w.raw, uint16(s))
+	}
+	w.md5.Write(w.raw)
+
+	bw := &w.bw
+	bw.b, bw.n = bw.b[:0], 0
+
+	// Stereo channels are encoded first to pick the channel assignment.
+	e := &w.enc
+	assign := uint64(w.chanCount - 1)
+	var stereo [2]channel
+	if w.chanCount == 2 {
+		assign, stereo = w.decorrelate(n)
+	}
+
+	// Frame header.
+	bsCode, bsBits := blockSizeCode(n)
+	bw.writeBits(0x3ffe, 14) // sync code
+	bw.writeBits(0, 1)       // reserved
+	bw.writeBits(0, 1)       // fixed block size
+	bw.writeBits(bsCode, 4)
+	bw.writeBits(rateCode(w.sampleRate), 4)
+	bw.writeBits(assign, 4)
+	bw.writeBits(4, 3) // 16 bits per sample
+	bw.writeBits(0, 1) // reserved
+	bw.b = appendCodedNumber(bw.b, w.frames)
+	if bsBits > 0 {
+		bw.writeBits(uint64(n-1), bsBits)
+	}
+	bw.b = append(bw.b, crc8(bw.b))
+
+	if w.chanCount == 2 {
+		writeSubframe(bw, &stereo[0])
+		writeSubframe(bw, &stereo[1])
+	} else {
+		for _, x := range w.chans {
+			ch := e.encodeChannel(x, bitsPerSample, &e.chanRes[0])
+			writeSubframe(bw, &ch)
+		}
+	}
+	bw.align()
+	bw.b = binary.BigEndian.AppendUint16(bw.b, crc16(bw.b))
+	return bw.b
+}
+
+// decorrelate returns the stereo channel assignment producing the smallest
+// frame, with its encoded channels.
+func (w *Writer) decorrelate(n int) (uint64, [2]channel) {
+	e := &w.enc
+	left, right := w.chans[0], w.chans[1]
+	if cap(e.side) < n {
+		e.mid, e.side = make([]int32, n), make([]int32, n)
+	}
+	mid, side := e.mid[:n], e.side[:n]
+	for i := range n {
+		mid[i] = (left[i] + right[i]) >> 1
+		side[i] = left[i] - right[i]
+	}
+
+	l := e.encodeChannel(left, bitsPerSample, &e.chanRes[0])
+	r := e.encodeChannel(right, bitsPerSample, &e.chanRes[1])
+	m := e.encodeChannel(mid, bitsPerSample, &e.chanRes[2])
+	s := e.encodeChannel(side, bitsPerSample+1, &e.chanRes[3]) // the difference needs an extra bit
+
+	switch min(l.sf.bits+r.sf.bits, l.sf.bits+s.sf.bits, s.sf.bits+r.sf.bits, m.sf.bits+s.sf.bits) {
+	case l.sf.bits + r.sf.bits:
+		return 1, [2]channel{l, r}
+	case l.sf.bits + s.sf.bits:
+		return 8, [2]channel{l, s}
+	case s.sf.bits + r.sf.bits:
+		return 9, [2]channel{s, r}
+	}
+	return 10, [2]channel{m, s}
+}
+
+// blockSizeCode returns the frame header code for a block of n samples, and
+// the number of bits of n-1 following the frame number, if any.
+func blockSizeCode(n int) (code uint64, extra uint) {
+	switch {
+	case n == 192:
+		return 1, 0
+	case n >= 576 && n <= 4608 && n%576 == 0 && (n/576)&(n/576-1) == 0:
+		for code = 2; 576<<(code-2) != n; code++ {
+		}
+		return code, 0
+	case n >= 256 && n <= 32768 && n&(n-1) == 0:
+		for code = 8; 256<<(code-8) != n; code++ {
+		}
+		return code, 0
+	case n <= 256:
+		return 6, 8
+	}
+	return 7, 16
+}
+
+// rateCode returns the frame header code for the sample rate, 0 if it must
+// be read from STREAMINFO.
+func rateCode(rate int) uint64 {
+	for code, r := range sampleRates {
+		if r == rate {
+			return uint64(code)
+		}
+	}
+	return 0
+}
+
+// appendCodedNumber appends v coded as an extended UTF-8 sequence, as used
+// for frame numbers.
+func appendCodedNumber(b []byte, v uint64) []byte {
+	if v < 0x80 {
+		return append(b, byte(v))
+	}
+	// An n byte sequence holds 5n+1 bits.
+	n := 2
+	for v >= 1<<(5*n+1) {
+		n++
+	}
+	b = append(b, byte(0xff<<(8-n))|byte(v>>(6*(n-1))))
+	for i := n - 2; i >= 0; i-- {
+		b = append(b, 0x80|byte(v>>(6*i))&0x3f)
+	}
+	return b
+}
+
+// Close encodes the remaining samples and finalizes the FLAC file. It must be
+// called when done writing samples.
+func (w *Writer) Close() error {
+	if err := w.finalize(); err != nil {
+		w.out.Close()
+		return err
+	}
+	return w.out.Close()
+}
+
+func (w *Writer) finalize() error {
+	if !w.out.Started() {
+		if err := w.begin(); err != nil {
+			return err
+		}
+	}
+
+	if len(w.pending)%w.chanCount != 0 {
+		return ErrIncompleteFrame
+	}
+	if len(w.pending) > 0 {
+		if err := w.writeFrame(); err != nil {
+			return err
+		}
+	}
+
+	// The header holds sizes and the MD5 signature, now known.
+	return w.out.Finish(w.header())
+}
@@ -0,0 +1,387 @@
+package flac
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/arl/blip"
+	"github.com/arl/blip/internal/pcmtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// squareSweep renders n samples of a square wave sweep with blip, like
+// demo_basic.
+func squareSweep(n int) []int16 {
+	const sampleRate = 44100
+	const clockRate = sampleRate * blip.MaxRatio
+
+	bl := blip.NewBuffer(sampleRate / 10)
+	bl.SetRates(clockRate, sampleRate)
+
+	var (
+		time   uint64
+		period = 1000 * blip.MaxRatio
+		amp    = int32(5000)
+		out    = make([]int16, 0, n)
+		buf    = make([]int16, 1024)
+	)
+	for len(out) < n {
+		clocks := uint64(bl.ClocksNeeded(len(buf)))
+		for ; time < clocks; time += uint64(period) {
+			amp = -amp
+			bl.AddDelta(time, amp*2)
+			period += blip.MaxRatio / 4
+		}
+		time -= clocks
+		bl.EndFrame(int(clocks))
+		k := bl.ReadSamples(buf, len(buf), blip.Mono)
+		out = append(out, buf[:k]...)
+	}
+	return out[:n]
+}
+
+func sine(n int, freq float64, amp float64) []int16 {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = int16(amp * math.Sin(2*math.Pi*freq*float64(i)/44100))
+	}
+	return s
+}
+
+func noise(n int) []int16 {
+	rnd := rand.New(rand.NewSource(1))
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = int16(rnd.Intn(1 << 16))
+	}
+	return s
+}
+
+// interleave interleaves the given channels.
+func interleave(chans ...[]int16) []int16 {
+	s := make([]int16, 0, len(chans)*len(chans[0]))
+	for i := range chans[0] {
+		for _, c := range chans {
+			s = append(s, c[i])
+		}
+	}
+	return s
+}
+
+func encode(t *testing.T, samples []int16, channels, blockSize int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.SetChannels(channels)
+	w.SetBlockSize(blockSize)
+	// Write in chunks not aligned on blocks.
+	for i := 0; i < len(samples); i += 1000 * channels {
+		if _, err := w.Write(samples[i:min(i+1000*channels, len(samples))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	sweep := squareSweep(20000)
+	tests := []struct {
+		name      string
+		samples   []int16
+		channels  int
+		blockSize int
+	}{
+		{"silence", make([]int16, 10000), 1, DefaultBlockSize},
+		{"single", []int16{1234}, 1, DefaultBlockSize},
+		{"short", []int16{1, -5, 20, 32767, -32768}, 1, DefaultBlockSize},
+		{"sweep", sweep, 1, DefaultBlockSize},
+		{"sine", sine(10000, 440, 30000), 1, 1152},
+		{"noise", noise(9000), 1, 4608},
+		{"odd block size", sweep, 1, 1000},
+		{"small blocks", sweep[:5000], 1, 16},
+		{"stereo", interleave(sweep, sine(20000, 1000, 8000)), 2, DefaultBlockSize},
+		{"stereo same", interleave(sweep, sweep), 2, DefaultBlockSize},
+		{"stereo opposite", interleave(sweep, invert(sweep)), 2, DefaultBlockSize},
+		{"stereo extremes", interleave(noise(5000), invert(noise(5000))), 2, 576},
+		{"3 channels", interleave(sweep[:8000], noise(8000), sine(8000, 100, 20000)), 3, 2048},
+	}
+	for _, tt := range tests {
+		b := encode(t, tt.samples, tt.channels, tt.blockSize)
+
+		r, err := NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if r.Channels() != tt.channels || r.SampleRate() != 44100 || r.BitsPerSample() != 16 {
+			t.Errorf("%s: got %d channels, %d Hz, %d bits", tt.name, r.Channels(), r.SampleRate(), r.BitsPerSample())
+		}
+		if r.SampleCount() != len(tt.samples) {
+			t.Errorf("%s: SampleCount = %d, want %d", tt.name, r.SampleCount(), len(tt.samples))
+		}
+		got, err := r.ReadAllInt16()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if diff := cmp.Diff(got, tt.samples); diff != "" {
+			t.Errorf("%s: samples mismatch (-got +want):\n%s", tt.name, diff)
+		}
+	}
+}
+
+func invert(s []int16) []int16 {
+	inv := make([]int16, len(s))
+	for i, v := range s {
+		inv[i] = ^v
+	}
+	return inv
+}
+
+func TestCompression(t *testing.T) {
+	tests := []struct {
+		name     string
+		samples  []int16
+		maxRatio float64 // of encoded size over raw size
+	}{
+		{"silence", make([]int16, 44100), 0.01},
+		{"sweep", squareSweep(44100), 0.25},
+		{"sine", sine(44100, 440, 30000), 0.25},
+		{"noise", noise(44100), 1.01},
+	}
+	for _, tt := range tests {
+		b := encode(t, tt.samples, 1, DefaultBlockSize)
+		ratio := float64(len(b)) / float64(2*len(tt.samples))
+		if ratio > tt.maxRatio {
+			t.Errorf("%s: compression ratio = %.3f, want <= %.3f", tt.name, ratio, tt.maxRatio)
+		}
+	}
+}
+
+func TestSubframeKinds(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []int16
+		kind    int
+	}{
+		{"silence", make([]int16, 4096), subConstant},
+		{"noise", noise(4096), subVerbatim},
+		{"ramp", func() []int16 {
+			s := make([]int16, 4096)
+			for i := range s {
+				s[i] = int16(i*3 - 5000)
+			}
+			return s
+		}(), subFixed},
+		{"sine", sine(4096, 3000, 20000), subLPC},
+	}
+	var e encoder
+	for _, tt := range tests {
+		x := make([]int32, len(tt.samples))
+		for i, s := range tt.samples {
+			x[i] = int32(s)
+		}
+		sf, _ := e.analyze(x, 16, make([]int32, len(x)))
+		if sf.kind != tt.kind {
+			t.Errorf("%s: subframe kind = %d, want %d", tt.name, sf.kind, tt.kind)
+		}
+	}
+}
+
+func TestStreamInfo(t *testing.T) {
+	samples := interleave(squareSweep(10000), sine(10000, 440, 1000))
+	b := encode(t, samples, 2, DefaultBlockSize)
+
+	if string(b[:4]) != "fLaC" || b[4] != 0x80 || b[7] != 34 {
+		t.Fatalf("header = % x", b[:8])
+	}
+	info := b[8:42]
+	if min, max := binary.BigEndian.Uint16(info), binary.BigEndian.Uint16(info[2:]); min != 4096 || max != 4096 {
+		t.Errorf("block sizes = %d, %d", min, max)
+	}
+	v := binary.BigEndian.Uint64(info[10:])
+	if rate, ch, bps, n := v>>44, v>>41&7+1, v>>36&0x1f+1, v&(1<<36-1); rate != 44100 || ch != 2 || bps != 16 || n != 10000 {
+		t.Errorf("STREAMINFO = %d Hz, %d channels, %d bits, %d samples", rate, ch, bps, n)
+	}
+
+	raw := make([]byte, 0, 2*len(samples))
+	for _, s := range samples {
+		raw = binary.LittleEndian.AppendUint16(raw, uint16(s))
+	}
+	if sum := md5.Sum(raw); !bytes.Equal(info[18:], sum[:]) {
+		t.Errorf("MD5 = %x, want %x", info[18:], sum)
+	}
+
+	maxFrame := int(info[7])<<16 | int(info[8])<<8 | int(info[9])
+	if minFrame := int(info[4])<<16 | int(info[5])<<8 | int(info[6]); minFrame == 0 || minFrame > maxFrame {
+		t.Errorf("frame sizes = %d, %d", minFrame, maxFrame)
+	}
+}
+
+func TestWriterSeekable(t *testing.T) {
+	samples := squareSweep(10000)
+
+	path := filepath.Join(t.TempDir(), "out.flac")
+	w, err := NewFile(path, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(samples)
+	if w.SampleCount() != len(samples) {
+		t.Errorf("SampleCount = %d, want %d", w.SampleCount(), len(samples))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := encode(t, samples, 1, DefaultBlockSize); !bytes.Equal(got, want) {
+		t.Fatalf("seekable and non-seekable outputs differ")
+	}
+}
+
+func TestWriterConversions(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 48000)
+	w.WriteInt32([]int32{-1 << 31, 1 << 30, 0x7fffffff})
+	w.WriteFloat32([]float32{-1, 0.5, 2})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAllFloat32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float32{-1, 0.5, 32767.0 / 32768, -1, 0.5, 32767.0 / 32768}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	b := encode(t, squareSweep(5000), 1, DefaultBlockSize)
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrFormat},
+		{"wave", []byte("RIFF\x00\x00\x00\x00WAVE"), ErrFormat},
+		{"no STREAMINFO", []byte("fLaC\x81\x00\x00\x00"), ErrFormat},
+		{"short STREAMINFO", []byte("fLaC\x80\x00\x00\x10" + string(make([]byte, 16))), ErrFormat},
+		{"truncated header", b[:30], ErrFormat},
+	}
+	for _, tt := range tests {
+		if _, err := NewReader(bytes.NewReader(tt.data)); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	corrupt := func(i int, mask byte) []byte {
+		c := bytes.Clone(b)
+		c[i] ^= mask
+		return c
+	}
+	tests = []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"frame header", corrupt(42+4, 0x01), ErrChecksum},
+		{"frame CRC", corrupt(len(b)-1, 0x01), ErrChecksum},
+		{"MD5", corrupt(40, 0xff), ErrChecksum},
+		{"sync", corrupt(42, 0xff), ErrFormat},
+		{"truncated", b[:len(b)-10], io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		r, err := NewReader(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if _, err := r.ReadAllInt16(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReaderHugeSizes(t *testing.T) {
+	// A metadata block claiming the largest possible size.
+	b := []byte("fLaC\x01\xff\xff\xff")
+	var err error
+	alloc := pcmtest.Allocated(func() { _, err = NewReader(bytes.NewReader(b)) })
+	if !errors.Is(err, ErrFormat) {
+		t.Errorf("huge block: err = %v, want ErrFormat", err)
+	}
+	if alloc > 1<<20 {
+		t.Errorf("huge block: allocated %d bytes", alloc)
+	}
+
+	// STREAMINFO claims the largest possible number of samples.
+	samples := squareSweep(5000)
+	b = encode(t, samples, 1, DefaultBlockSize)
+	b[21] |= 0x0f
+	copy(b[22:26], "\xff\xff\xff\xff")
+	r, err := NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int16
+	alloc = pcmtest.Allocated(func() { got, err = r.ReadAllInt16() })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, samples); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+	if alloc > 1<<20 {
+		t.Errorf("huge count: allocated %d bytes", alloc)
+	}
+}
+
+func TestCodedNumber(t *testing.T) {
+	tests := []struct {
+		v    uint64
+		want string
+	}{
+		{0, "\x00"},
+		{0x7f, "\x7f"},
+		{0x80, "\xc2\x80"},
+		{0x7ff, "\xdf\xbf"},
+		{0x800, "\xe0\xa0\x80"},
+		{0xffff, "\xef\xbf\xbf"},
+		{0x10000, "\xf0\x90\x80\x80"},
+		{1<<36 - 1, "\xfe\xbf\xbf\xbf\xbf\xbf\xbf"},
+	}
+	for _, tt := range tests {
+		if got := appendCodedNumber(nil, tt.v); string(got) != tt.want {
+			t.Errorf("appendCodedNumber(%#x) = % x, want % x", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestIncompleteFrame(t *testing.T) {
+	w := NewWriter(io.Discard, 44100)
+	w.EnableStereo()
+	w.Write([]int16{1, 2, 3})
+	if err := w.Close(); err != ErrIncompleteFrame {
+		t.Errorf("Close = %v, want ErrIncompleteFrame", err)
+	}
+}
@@ -0,0 +1,629 @@
+package flac
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+
+	"github.com/arl/blip/internal/pcm"
+)
+
+var (
+	// ErrFormat is returned when reading data that isn't a valid FLAC file.
+	ErrFormat = errors.New("flac: invalid format")
+
+	// ErrUnsupported is returned when reading a valid FLAC file using
+	// features that aren't supported.
+	ErrUnsupported = errors.New("flac: unsupported format")
+
+	// ErrChecksum is returned when a frame CRC or the MD5 signature of
+	// decoded samples doesn't match.
+	ErrChecksum = errors.New("flac: checksum mismatch")
+)
+
+// A Reader decodes samples from a FLAC file.
+//
+// Samples of any size up to 32 bits can be read as int16 or float32, they're
+// converted on the fly. Multichannel samples are interleaved. Once all
+// samples have been read, their MD5 signature is checked against the one of
+// the STREAMINFO header, if set.
+type Reader struct {
+	br bitReader
+	c  io.Closer // nil if the source isn't owned
+
+	channels   int
+	sampleRate int
+	bits       int
+	remain     int64 // samples remaining, -1 if unknown
+	sum        [md5.Size]byte
+
+	md5     hash.Hash
+	samples []int32   // interleaved samples of the current frame
+	pos     int       // next sample to read in samples
+	chans   [][]int32 // decoded channels of the current frame
+	raw     []byte
+	eof     bool
+}
+
+// NewReader creates a Reader reading a FLAC file from r. It parses the
+// metadata blocks up to the first frame.
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{br: bitReader{r: bufio.NewReader(r)}, md5: md5.New()}
+	if err := rd.readHeader(); err != nil {
+		return nil, err
+	}
+	return rd, nil
+}
+
+// Open opens the FLAC file at path for reading. Close must be called when done
+// reading samples.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.c = f
+	return r, nil
+}
+
+// Close closes the file opened by Open. It does nothing for a Reader created
+// with NewReader.
+func (r *Reader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// Channels returns the number of channels.
+func (r *Reader) Channels() int { return r.channels }
+
+// SampleRate returns the number of sample frames per second.
+func (r *Reader) SampleRate() int { return r.sampleRate }
+
+// BitsPerSample returns the size of a single sample, in bits.
+func (r *Reader) BitsPerSample() int { return r.bits }
+
+// Float always returns false, FLAC samples are integers.
+func (r *Reader) Float() bool { return false }
+
+// SampleCount returns the number of samples (of all channels) that remain to
+// be read, or -1 if the file doesn't specify it.
+func (r *Reader) SampleCount() int {
+	if r.remain < 0 {
+		return -1
+	}
+	return int(r.remain)
+}
+
+func (r *Reader) readHeader() error {
+	var b [4]byte
+	if _, err := io.ReadFull(r.br.r, b[:]); err != nil || string(b[:]) != "fLaC" {
+		return ErrFormat
+	}
+
+	gotInfo := false
+	for {
+		if _, err := io.ReadFull(r.br.r, b[:]); err != nil {
+			return fmt.Errorf("%w: truncated metadata block", ErrFormat)
+		}
+		last := b[0]&0x80 != 0
+		typ := b[0] & 0x7f
+		size := int(b[1])<<16 | int(b[2])<<8 | int(b[3])
+
+		// Only STREAMINFO is read, other blocks may be large and are skipped.
+		if typ == 0 {
+			if size < streamInfoSize {
+				return fmt.Errorf("%w: STREAMINFO block too short", ErrFormat)
+			}
+			var info [streamInfoSize]byte
+			if _, err := io.ReadFull(r.br.r, info[:]); err != nil {
+				return fmt.Errorf("%w: truncated metadata block", ErrFormat)
+			}
+			if err := r.readStreamInfo(info[:]); err != nil {
+				return err
+			}
+			size -= streamInfoSize
+			gotInfo = true
+		}
+		if _, err := io.CopyN(io.Discard, r.br.r, int64(size)); err != nil {
+			return fmt.Errorf("%w: truncated metadata block", ErrFormat)
+		}
+		if last {
+			break
+		}
+	}
+	if !gotInfo {
+		return fmt.Errorf("%w: missing STREAMINFO block", ErrFormat)
+	}
+	return nil
+}
+
+// streamInfoSize is the size of the STREAMINFO block body.
+const streamInfoSize = 34
+
+func (r *Reader) readStreamInfo(b []byte) error {
+	v := binary.BigEndian.Uint64(b[10:])
+	r.sampleRate = int(v >> 44)
+	r.channels = int(v>>41&7) + 1
+	r.bits = int(v>>36&0x1f) + 1
+	r.remain = int64(v&(1<<36-1)) * int64(r.channels)
+	if r.remain == 0 {
+		r.remain = -1
+	}
+	copy(r.sum[:], b[18:34])
+
+	if r.sampleRate == 0 || r.bits < 4 {
+		return fmt.Errorf("%w: invalid STREAMINFO block", ErrFormat)
+	}
+	return nil
+}
+
+// readFrame decodes the next frame into r.samples. It returns io.EOF at the
+// end of the stream, after checking the MD5 signature.
+func (r *Reader) readFrame() error {
+	br := &r.br
+	br.resetCRC()
+	sync, err := br.readBits(15)
+	if err == io.EOF && br.n == 0 {
+		var zero [md5.Size]byte
+		if r.sum != zero && !bytes.Equal(r.md5.Sum(nil), r.sum[:]) {
+			return fmt.Errorf("%w: MD5 signature", ErrChecksum)
+		}
+		return io.EOF
+	}
+	if err != nil {
+		return unexpected(err)
+	}
+	if sync != 0x7ffc {
+		return fmt.Errorf("%w: missing frame sync code", ErrFormat)
+	}
+
+	h, err := r.readFrameHeader()
+	if err != nil {
+		return err
+	}
+
+	if len(r.chans) != r.channels {
+		r.chans = make([][]int32, r.channels)
+	}
+	for c := range r.chans {
+		if cap(r.chans[c]) < h.blockSize {
+			r.chans[c] = make([]int32, h.blockSize)
+		}
+		r.chans[c] = r.chans[c][:h.blockSize]
+
+		bps := h.bits
+		if h.assign == 8 && c == 1 || h.assign == 9 && c == 0 || h.assign == 10 && c == 1 {
+			bps++ // side channel
+		}
+		if err := r.readSubframe(r.chans[c], bps); err != nil {
+			return err
+		}
+	}
+
+	br.align()
+	want := br.crc16
+	crc, err := br.readBits(16)
+	if err != nil {
+		return unexpected(err)
+	}
+	if uint16(crc) != want {
+		return fmt.Errorf("%w: frame CRC-16", ErrChecksum)
+	}
+
+	r.interleave(h.assign, h.blockSize)
+	return nil
+}
+
+type frameHeader struct {
+	blockSize int
+	assign    int // channel assignment
+	bits      int
+}
+
+func (r *Reader) readFrameHeader() (frameHeader, error) {
+	br := &r.br
+	var h frameHeader
+
+	// The blocking strategy only changes the meaning of the coded number.
+	v, err := br.readBits(1 + 4 + 4 + 4 + 3 + 1)
+	if err != nil {
+		return h, unexpected(err)
+	}
+	bsCode := v >> 12 & 0xf
+	rCode := v >> 8 & 0xf
+	h.assign = int(v >> 4 & 0xf)
+	sCode := v >> 1 & 7
+
+	// Coded sample or frame number.
+	first, err := br.readBits(8)
+	if err != nil {
+		return h, unexpected(err)
+	}
+	for mask := uint64(0x40); first&0x80 != 0 && first&mask != 0; mask >>= 1 {
+		if _, err := br.readBits(8); err != nil {
+			return h, unexpected(err)
+		}
+	}
+
+	switch {
+	case bsCode == 0:
+		return h, fmt.Errorf("%w: reserved block size", ErrFormat)
+	case bsCode == 1:
+		h.blockSize = 192
+	case bsCode <= 5:
+		h.blockSize = 576 << (bsCode - 2)
+	case bsCode == 6, bsCode == 7:
+		n, err := br.readBits(8 << (bsCode - 6))
+		if err != nil {
+			return h, unexpected(err)
+		}
+		h.blockSize = int(n) + 1
+	default:
+		h.blockSize = 256 << (bsCode - 8)
+	}
+
+	switch {
+	case rCode == 12:
+		_, err = br.readBits(8)
+	case rCode == 13, rCode == 14:
+		_, err = br.readBits(16)
+	case rCode == 15:
+		return h, fmt.Errorf("%w: invalid sample rate", ErrFormat)
+	}
+	if err != nil {
+		return h, unexpected(err)
+	}
+
+	switch {
+	case h.assign < 8:
+		if h.assign+1 != r.channels {
+			return h, fmt.Errorf("%w: channel count changed", ErrUnsupported)
+		}
+	case h.assign <= 10:
+		if r.channels != 2 {
+			return h, fmt.Errorf("%w: stereo decorrelation of %d channels", ErrFormat, r.channels)
+		}
+	default:
+		return h, fmt.Errorf("%w: reserved channel assignment", ErrFormat)
+	}
+
+	h.bits = [8]int{r.bits, 8, 12, 0, 16, 20, 24, 32}[sCode]
+	if h.bits == 0 {
+		return h, fmt.Errorf("%w: reserved sample size", ErrFormat)
+	}
+	if h.bits != r.bits {
+		return h, fmt.Errorf("%w: sample size changed", ErrUnsupported)
+	}
+
+	want := br.crc8
+	crc, err := br.readBits(8)
+	if err != nil {
+		return h, unexpected(err)
+	}
+	if uint8(crc) != want {
+		return h, fmt.Errorf("%w: frame header CRC-8", ErrChecksum)
+	}
+	return h, nil
+}
+
+// readSubframe decodes a subframe into x, with bps bits per sample.
+func (r *Reader) readSubframe(x []int32, bps int) error {
+	br := &r.br
+	v, err := br.readBits(8)
+	if err != nil {
+		return unexpected(err)
+	}
+	if v&0x80 != 0 {
+		return fmt.Errorf("%w: invalid subframe header", ErrFormat)
+	}
+	typ := int(v >> 1 & 0x3f)
+
+	// Wasted bits are zero bits removed from all samples.
+	wasted := 0
+	if v&1 != 0 {
+		k, err := br.readUnary()
+		if err != nil {
+			return unexpected(err)
+		}
+		wasted = int(k) + 1
+		if wasted >= bps {
+			return fmt.Errorf("%w: too many wasted bits", ErrFormat)
+		}
+		bps -= wasted
+	}
+
+	switch {
+	case typ == 0:
+		s, err := br.readSigned(uint(bps))
+		if err != nil {
+			return unexpected(err)
+		}
+		for i := range x {
+			x[i] = int32(s)
+		}
+	case typ == 1:
+		if err := r.readWarmup(x, bps); err != nil {
+			return err
+		}
+	case typ >= 8 && typ <= 12:
+		order := typ - 8
+		if err := r.readPredicted(x, bps, order, nil, 0); err != nil {
+			return err
+		}
+	case typ >= 32:
+		order := typ - 31
+		if order > len(x) {
+			return fmt.Errorf("%w: LPC order larger than block", ErrFormat)
+		}
+		if err := r.readLPC(x, bps, order); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("%w: reserved subframe type", ErrFormat)
+	}
+
+	if wasted > 0 {
+		for i := range x {
+			x[i] <<= wasted
+		}
+	}
+	return nil
+}
+
+// readWarmup reads len(x) unencoded samples.
+func (r *Reader) readWarmup(x []int32, bps int) error {
+	for i := range x {
+		s, err := r.br.readSigned(uint(bps))
+		if err != nil {
+			return unexpected(err)
+		}
+		x[i] = int32(s)
+	}
+	return nil
+}
+
+func (r *Reader) readLPC(x []int32, bps, order int) error {
+	br := &r.br
+	if err := r.readWarmup(x[:order], bps); err != nil {
+		return err
+	}
+	v, err := br.readBits(4)
+	if err != nil {
+		return unexpected(err)
+	}
+	if v == 15 {
+		return fmt.Errorf("%w: invalid LPC precision", ErrFormat)
+	}
+	precision := uint(v) + 1
+	shift, err := br.readSigned(5)
+	if err != nil {
+		return unexpected(err)
+	}
+	if shift < 0 {
+		return fmt.Errorf("%w: negative LPC shift", ErrFormat)
+	}
+
+	var coefs [32]int32
+	for i := range order {
+		c, err := br.readSigned(precision)
+		if err != nil {
+			return unexpected(err)
+		}
+		coefs[i] = int32(c)
+	}
+	return r.readPredicted(x, bps, order, coefs[:order], int(shift))
+}
+
+// readPredicted reads the warmup samples and residual of a fixed subframe,
+// or the residual of an LPC subframe when coefs is set, and restores the
+// samples.
+func (r *Reader) readPredicted(x []int32, bps, order int, coefs []int32, shift int) error {
+	if order > len(x) {
+		return fmt.Errorf("%w: predictor order larger than block", ErrFormat)
+	}
+	if coefs == nil {
+		if err := r.readWarmup(x[:order], bps); err != nil {
+			return err
+		}
+	}
+	if err := r.readResidual(x[order:], len(x), order); err != nil {
+		return err
+	}
+
+	if coefs != nil {
+		for i := order; i < len(x); i++ {
+			var sum int64
+			for j, c := range coefs {
+				sum += int64(c) * int64(x[i-1-j])
+			}
+			x[i] += int32(sum >> shift)
+		}
+		return nil
+	}
+	for i := order; i < len(x); i++ {
+		switch order {
+		case 1:
+			x[i] += x[i-1]
+		case 2:
+			x[i] += 2*x[i-1] - x[i-2]
+		case 3:
+			x[i] += 3*x[i-1] - 3*x[i-2] + x[i-3]
+		case 4:
+			x[i] += 4*x[i-1] - 6*x[i-2] + 4*x[i-3] - x[i-4]
+		}
+	}
+	return nil
+}
+
+// readResidual reads the Rice coded residual res of a block of n samples
+// predicted with the given order.
+func (r *Reader) readResidual(res []int32, n, order int) error {
+	br := &r.br
+	v, err := br.readBits(2 + 4)
+	if err != nil {
+		return unexpected(err)
+	}
+	method, po := v>>4, int(v&0xf)
+	if method > 1 {
+		return fmt.Errorf("%w: reserved residual coding method", ErrFormat)
+	}
+	paramBits, escape := uint(4), uint64(15)
+	if method == 1 {
+		paramBits, escape = 5, 31
+	}
+	if n%(1<<po) != 0 || n>>po < order {
+		return fmt.Errorf("%w: invalid partition order", ErrFormat)
+	}
+
+	start := 0
+	for i := range 1 << po {
+		end := (i+1)*(n>>po) - order
+		k, err := br.readBits(paramBits)
+		if err != nil {
+			return unexpected(err)
+		}
+		if k == escape {
+			size, err := br.readBits(5)
+			if err != nil {
+				return unexpected(err)
+			}
+			if err := r.readWarmup(res[start:end], int(size)); err != nil {
+				return err
+			}
+		} else {
+			for j := start; j < end; j++ {
+				if res[j], err = br.readRice(uint(k)); err != nil {
+					return unexpected(err)
+				}
+			}
+		}
+		start = end
+	}
+	return nil
+}
+
+// interleave undoes the stereo decorrelation of the decoded channels, and
+// interleaves them into r.samples.
+func (r *Reader) interleave(assign, n int) {
+	switch assign {
+	case 8: // left, side
+		left, side := r.chans[0], r.chans[1]
+		for i := range n {
+			side[i] = left[i] - side[i]
+		}
+	case 9: // side, right
+		side, right := r.chans[0], r.chans[1]
+		for i := range n {
+			side[i] += right[i]
+		}
+	case 10: // mid, side
+		mid, side := r.chans[0], r.chans[1]
+		for i := range n {
+			m := mid[i]<<1 | side[i]&1
+			mid[i], side[i] = (m+side[i])>>1, (m-side[i])>>1
+		}
+	}
+
+	r.samples = r.samples[:0]
+	r.raw = r.raw[:0]
+	size := (r.bits + 7) / 8
+	for i := range n {
+		for _, x := range r.chans {
+			s := x[i]
+			r.samples = append(r.samples, s)
+			for b := range size {
+				r.raw = append(r.raw, byte(s>>(8*b)))
+			}
+		}
+	}
+	r.md5.Write(r.raw)
+	r.pos = 0
+}
+
+// unexpected turns an EOF in the middle of a frame into io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// next returns the next decoded sample, as a signed integer using all 32 bits.
+func (r *Reader) next() (int32, error) {
+	if r.pos == len(r.samples) {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.readFrame(); err != nil {
+			if err == io.EOF {
+				r.eof = true
+			}
+			return 0, err
+		}
+	}
+	s := r.samples[r.pos]
+	r.pos++
+	if r.remain > 0 {
+		r.remain--
+	}
+	return s << (32 - r.bits), nil
+}
+
+// read reads at most len(p) samples, converted by conv. It returns io.EOF at
+// the end of the stream.
+func read[T any](r *Reader, p []T, conv func(int32) T) (int, error) {
+	for i := range p {
+		s, err := r.next()
+		if err != nil {
+			if i > 0 && err == io.EOF {
+				return i, nil
+			}
+			return i, err
+		}
+		p[i] = conv(s)
+		// Don't decode a new frame once some samples have been read.
+		if r.pos == len(r.samples) {
+			return i + 1, nil
+		}
+	}
+	return len(p), nil
+}
+
+// ReadInt16 reads at most len(p) samples into p, converting them to 16-bit
+// signed integers. It returns the number of samples read and io.EOF at the end
+// of the stream.
+func (r *Reader) ReadInt16(p []int16) (int, error) {
+	return read(r, p, func(s int32) int16 { return int16(s >> 16) })
+}
+
+// ReadFloat32 reads at most len(p) samples into p, converting them to floats
+// in the [-1, 1] range. It returns the number of samples read and io.EOF at
+// the end of the stream.
+func (r *Reader) ReadFloat32(p []float32) (int, error) {
+	return read(r, p, func(s int32) float32 { return float32(float64(s) / (1 << 31)) })
+}
+
+// ReadAllInt16 reads all remaining samples, converted to 16-bit signed
+// integers.
+func (r *Reader) ReadAllInt16() ([]int16, error) {
+	return pcm.ReadAll(r.ReadInt16, r.SampleCount())
+}
+
+// ReadAllFloat32 reads all remaining samples, converted to floats in the
+// [-1, 1] range.
+func (r *Reader) ReadAllFloat32() ([]float32, error) {
+	return pcm.ReadAll(r.ReadFloat32, r.SampleCount())
+}
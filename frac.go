@@ -0,0 +1,113 @@
+package blip
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// FracBits is the number of fraction bits of fractional clock times, as
+	// taken by AddDeltaFrac, EndFrameFrac and ClocksNeededFrac.
+	FracBits = 16
+
+	// FracUnit is one clock in fractional clock time.
+	FracUnit = 1 << FracBits
+)
+
+// fracPos returns the buffer position of the fractional clock time, in time
+// units.
+func (b *Buffer) fracPos(time uint64) uint64 {
+	hi, lo := bits.Mul64(time, b.factor)
+	return (hi<<(64-FracBits) | lo>>FracBits) + b.offset
+}
+
+// AddDeltaFrac is like AddDelta, time being a fractional clock time with
+// FracBits fraction bits. This gives exact timing to synthesizers whose
+// periods aren't whole numbers of clocks, without raising the clock rate.
+func (b *Buffer) AddDeltaFrac(time uint64, delta int32) {
+	if b.deferred {
+		b.queue(time, FracBits, delta, stepDelta)
+		return
+	}
+	b.addDelta(b.fracPos(time)>>preShift, delta)
+}
+
+// AddDeltaFastFrac is like AddDeltaFast, time being a fractional clock time
+// with FracBits fraction bits.
+func (b *Buffer) AddDeltaFastFrac(time uint64, delta int32) {
+	if b.deferred {
+		b.queue(time, FracBits, delta, fastDelta)
+		return
+	}
+	b.addDeltaFast(b.fracPos(time)>>preShift, delta)
+}
+
+// EndFrameFrac is like EndFrame, clockDuration being a fractional clock time
+// with FracBits fraction bits. The next time frame begins at that fractional
+// clock.
+func (b *Buffer) EndFrameFrac(clockDuration uint64) {
+	// Fails if the frame ends before the last rate change
+	if clockDuration < b.pivot<<FracBits {
+		panic("time frame ends before rate change")
+	}
+	if b.deferred {
+		b.endFrameDeferred(clockDuration, FracBits)
+		return
+	}
+	off := b.fracPos(clockDuration)
+	b.advance(int(off>>timeBits), off&(timeUnit-1), clockDuration)
+}
+
+// ClocksNeededFrac is like ClocksNeeded, returning a fractional clock time
+// with FracBits fraction bits.
+func (b *Buffer) ClocksNeededFrac(nsamples int) uint64 {
+	if b.deferred {
+		return b.clocksNeededDeferred(nsamples, FracBits)
+	}
+
+	// Fails if buffer can't hold that many more samples
+	if nsamples < 0 || b.avail+nsamples > b.size {
+		panic("buffer can't hold that many samples")
+	}
+
+	needed := uint64(nsamples) * timeUnit
+	if b.pivot > 0 {
+		// The time frame can't end before the last rate change, past which
+		// offset may have wrapped around.
+		if needed <= b.pivotPos {
+			return b.pivot << FracBits
+		}
+	} else if needed < b.offset {
+		return 0
+	}
+
+	// ceil((needed-offset) * FracUnit / factor), in 128 bits.
+	d := needed - b.offset
+	hi, lo := d>>(64-FracBits), d<<FracBits
+	lo, carry := bits.Add64(lo, b.factor-1, 0)
+	q, _ := bits.Div64(hi+carry, lo, b.factor)
+	return q
+}
+
+// secondsToFrac converts a duration in seconds to fractional clocks.
+func (b *Buffer) secondsToFrac(secs float64) uint64 {
+	return uint64(math.Round(secs * b.clockRate * FracUnit))
+}
+
+// AddDeltaSeconds is like AddDelta, time being given in seconds since the
+// beginning of the time frame. Seconds are converted to clocks at the clock
+// rate last set with SetRates or SetRatesAt, with FracBits fraction bits of
+// precision.
+func (b *Buffer) AddDeltaSeconds(secs float64, delta int32) {
+	b.AddDeltaFrac(b.secondsToFrac(secs), delta)
+}
+
+// EndFrameSeconds is like EndFrame, the duration being given in seconds.
+func (b *Buffer) EndFrameSeconds(secs float64) {
+	b.EndFrameFrac(b.secondsToFrac(secs))
+}
+
+// SecondsNeeded is like ClocksNeeded, returning a duration in seconds.
+func (b *Buffer) SecondsNeeded(nsamples int) float64 {
+	return float64(b.ClocksNeededFrac(nsamples)) / FracUnit / b.clockRate
+}
@@ -0,0 +1,100 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func readAll(bl *Buffer) []int16 {
+	out := make([]int16, bl.SamplesAvailable())
+	bl.ReadSamples(out, len(out), Mono)
+	return out
+}
+
+func TestAddDeltaFrac(t *testing.T) {
+	const size = 1000
+
+	// Whole clocks match AddDelta.
+	want := NewBuffer(size)
+	want.SetRates(1789773, 44100)
+	got := NewBuffer(size)
+	got.SetRates(1789773, 44100)
+	for i := range uint64(50) {
+		want.AddDelta(i*731, 1000)
+		got.AddDeltaFrac(i*731<<FracBits, 1000)
+		want.AddDeltaFast(i*731+300, -1000)
+		got.AddDeltaFastFrac((i*731+300)<<FracBits, -1000)
+	}
+	want.EndFrame(50 * 731)
+	got.EndFrameFrac(50 * 731 << FracBits)
+	if diff := cmp.Diff(readAll(got), readAll(want)); diff != "" {
+		t.Errorf("whole clocks mismatch (-got +want):\n%s", diff)
+	}
+
+	// Half clocks match whole clocks at twice the clock rate.
+	want = NewBuffer(size)
+	want.SetRates(1<<21, 44100)
+	got = NewBuffer(size)
+	got.SetRates(1<<20, 44100)
+	for f := range 3 {
+		for i := range uint64(40) {
+			want.AddDelta(i*1001, 1000)
+			got.AddDeltaFrac(i*1001*FracUnit/2, 1000)
+		}
+		// Odd frame lengths, so that frames begin on half clocks.
+		want.EndFrame(40*1001 + 1)
+		got.EndFrameFrac((40*1001 + 1) * FracUnit / 2)
+		if diff := cmp.Diff(readAll(got), readAll(want)); diff != "" {
+			t.Errorf("frame %d: half clocks mismatch (-got +want):\n%s", f, diff)
+		}
+	}
+}
+
+func TestClocksNeededFrac(t *testing.T) {
+	bl := NewBuffer(MaxFrame)
+	bl.SetRates(1789773, 44100)
+	for _, n := range []int{1, 10, 735, MaxFrame} {
+		bl.Clear()
+		clocks := bl.ClocksNeededFrac(n)
+
+		// Whole clocks are never fewer than fractional ones.
+		if whole := uint64(bl.ClocksNeeded(n)) << FracBits; whole < clocks || whole-clocks >= FracUnit {
+			t.Errorf("ClocksNeededFrac(%d) = %d, ClocksNeeded = %d", n, clocks, whole>>FracBits)
+		}
+
+		// The fractional duration is exact.
+		bl.EndFrameFrac(clocks - 1)
+		assert(t, bl.SamplesAvailable(), n-1)
+		bl.Clear()
+		bl.EndFrameFrac(clocks)
+		assert(t, bl.SamplesAvailable(), n)
+	}
+}
+
+func TestSeconds(t *testing.T) {
+	const clockRate = 1 << 20
+
+	want := NewBuffer(1000)
+	want.SetRates(clockRate, 44100)
+	got := NewBuffer(1000)
+	got.SetRates(clockRate, 44100)
+
+	// 440.5 Hz square wave.
+	const half = 1 / 440.5 / 2
+	for i := range 16 {
+		want.AddDeltaFrac(uint64(i)*clockRate*FracUnit/881, 1000)
+		got.AddDeltaSeconds(float64(i)*half, 1000)
+	}
+	want.EndFrameFrac(16 * clockRate * FracUnit / 881)
+	got.EndFrameSeconds(16 * half)
+	if diff := cmp.Diff(readAll(got), readAll(want)); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+
+	got.Clear()
+	secs := got.SecondsNeeded(441)
+	if secs < 0.01-1e-6 || secs > 0.01+1e-6 {
+		t.Errorf("SecondsNeeded(441) = %v, want about 0.01", secs)
+	}
+}
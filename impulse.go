@@ -0,0 +1,70 @@
+package blip
+
+// AddImpulse adds a band-limited impulse into buffer at specified clock time.
+//
+// The impulse is the limit of a pulse of height h lasting w clocks as w gets
+// shorter, with h*w/c = amount, c being the number of clocks per sample. Its
+// area is thus amount amplitude units times one output sample, and it's
+// heard KernelDelay samples after time, like steps. Pulses narrower than a
+// sample can be rendered as impulses, as well as impulse-train oscillators
+// (BLIT), whose integral is a sawtooth or square wave, without aliasing.
+func (bl *Buffer) AddImpulse(time uint64, amount int32) {
+	if bl.deferred {
+		bl.queue(time, 0, amount, impulseDelta)
+		return
+	}
+
+	fixed := (time*bl.factor + bl.offset) >> preShift
+
+	// Fails if buffer size was exceeded
+	if uint64(bl.avail)+(fixed>>fracBits) > uint64(bl.size)+endFrameExtra {
+		if !bl.reserveDelta(fixed >> fracBits) {
+			return
+		}
+	}
+	bl.renderImpulse(uint64(bl.avail)<<fracBits+fixed, amount)
+}
+
+// renderImpulse adds an impulse at the given position of the sample storage,
+// in units of 1<<preShift time units.
+//
+// The impulse is the derivative of the step kernel with respect to its
+// position, that is the difference between the kernels of 2 consecutive
+// phases, which render interpolates between.
+func (bl *Buffer) renderImpulse(fixed uint64, amount int32) {
+	if bl.synthesis != BandLimited {
+		bl.renderFast(fixed, amount)
+		bl.renderFast(fixed+1<<fracBits, -amount)
+		return
+	}
+
+	const phaseShift = fracBits - phaseBits
+	phase := fixed >> phaseShift & (phaseCount - 1)
+
+	amount *= phaseCount
+
+	out := bl.samples[fixed>>fracBits:]
+	bl.written(fixed>>fracBits + 2*halfWidth)
+
+	idx := phase * halfWidth
+
+	out[0] += (int32(blStep[idx+0]) - int32(blStep[idx+halfWidth+0])) * amount
+	out[1] += (int32(blStep[idx+1]) - int32(blStep[idx+halfWidth+1])) * amount
+	out[2] += (int32(blStep[idx+2]) - int32(blStep[idx+halfWidth+2])) * amount
+	out[3] += (int32(blStep[idx+3]) - int32(blStep[idx+halfWidth+3])) * amount
+	out[4] += (int32(blStep[idx+4]) - int32(blStep[idx+halfWidth+4])) * amount
+	out[5] += (int32(blStep[idx+5]) - int32(blStep[idx+halfWidth+5])) * amount
+	out[6] += (int32(blStep[idx+6]) - int32(blStep[idx+halfWidth+6])) * amount
+	out[7] += (int32(blStep[idx+7]) - int32(blStep[idx+halfWidth+7])) * amount
+
+	rev := (phaseCount - phase) * halfWidth
+
+	out[8] += (int32(blStep[rev+7]) - int32(blStep[rev-1])) * amount
+	out[9] += (int32(blStep[rev+6]) - int32(blStep[rev-2])) * amount
+	out[10] += (int32(blStep[rev+5]) - int32(blStep[rev-3])) * amount
+	out[11] += (int32(blStep[rev+4]) - int32(blStep[rev-4])) * amount
+	out[12] += (int32(blStep[rev+3]) - int32(blStep[rev-5])) * amount
+	out[13] += (int32(blStep[rev+2]) - int32(blStep[rev-6])) * amount
+	out[14] += (int32(blStep[rev+1]) - int32(blStep[rev-7])) * amount
+	out[15] += (int32(blStep[rev+0]) - int32(blStep[rev-8])) * amount
+}
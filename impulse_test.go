@@ -0,0 +1,52 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddImpulse(t *testing.T) {
+	const (
+		clocksPerSample = 1024
+		height          = 32000
+		width           = 16 // clocks
+	)
+
+	// Narrow pulses and the equivalent impulses, at various phases.
+	pulses := NewBuffer(1000)
+	pulses.SetRates(44100*clocksPerSample, 44100)
+	impulses := NewBuffer(1000)
+	impulses.SetRates(44100*clocksPerSample, 44100)
+	for i := range uint64(20) {
+		time := (i*20+1)*clocksPerSample + i*97
+		pulses.AddDelta(time, height)
+		pulses.AddDelta(time+width, -height)
+		impulses.AddImpulse(time+width/2, height*width/clocksPerSample)
+	}
+	pulses.EndFrame(400 * clocksPerSample)
+	impulses.EndFrame(400 * clocksPerSample)
+
+	got, want := readAll(impulses), readAll(pulses)
+	for i := range got {
+		if d := int(got[i]) - int(want[i]); d < -2 || d > 2 {
+			t.Fatalf("sample %d = %d, want about %d", i, got[i], want[i])
+		}
+	}
+
+	// Impulses in deferred mode match immediate ones.
+	deferred := NewBuffer(1000)
+	deferred.SetRates(44100*clocksPerSample, 44100)
+	deferred.SetDeferred(true)
+	impulses.Clear()
+	for i := range uint64(20) {
+		time := i*20*clocksPerSample + i*97
+		deferred.AddImpulse(time, 1000)
+		impulses.AddImpulse(time, 1000)
+	}
+	deferred.EndFrame(400 * clocksPerSample)
+	impulses.EndFrame(400 * clocksPerSample)
+	if diff := cmp.Diff(readAll(deferred), readAll(impulses)); diff != "" {
+		t.Errorf("deferred samples mismatch (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,103 @@
+// Package patch writes files starting with a header that depends on the data
+// following it, such as its size, and is only known once all data is written.
+package patch
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// A Writer writes a header followed by data.
+//
+// Data is streamed to the destination as it's written. If the destination is
+// an io.WriteSeeker, such as the *os.File created by NewFile, a placeholder
+// header is written by Begin and patched by Finish. Otherwise, data is
+// buffered in memory until Finish, where the header can be written first.
+type Writer struct {
+	w       io.Writer
+	c       io.Closer // closed on Close, nil if the destination isn't owned
+	started bool
+	ws      io.WriteSeeker // nil if the destination can't seek
+	start   int64          // header offset in ws
+	bb      bytes.Buffer   // data, when ws is nil
+}
+
+// New returns a Writer writing to w. Close doesn't close w.
+func New(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// NewFile creates a file at the given path and returns a Writer writing to it.
+// Close closes the file.
+func NewFile(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: f, c: f}, nil
+}
+
+// Started reports whether Begin has been called.
+func (w *Writer) Started() bool {
+	return w.started
+}
+
+// Begin writes a placeholder header if the destination can seek back to patch
+// it later. Otherwise, it prepares for buffering data. It must be called once,
+// before data is written.
+func (w *Writer) Begin(header []byte) error {
+	w.started = true
+
+	ws, ok := w.w.(io.WriteSeeker)
+	if !ok {
+		return nil
+	}
+	// Some seekers, like pipes, fail to seek.
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil
+	}
+	w.ws, w.start = ws, start
+
+	_, err = w.w.Write(header)
+	return err
+}
+
+// Write writes data following the header.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.ws == nil {
+		return w.bb.Write(p)
+	}
+	return w.w.Write(p)
+}
+
+// Finish writes the final header, which must have the size of the placeholder
+// header, followed by buffered data if any.
+func (w *Writer) Finish(header []byte) error {
+	if w.ws == nil {
+		if _, err := w.w.Write(header); err != nil {
+			return err
+		}
+		_, err := w.bb.WriteTo(w.w)
+		return err
+	}
+
+	// Patch the header now that it's known.
+	if _, err := w.ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := w.ws.Write(header); err != nil {
+		return err
+	}
+	_, err := w.ws.Seek(0, io.SeekEnd)
+	return err
+}
+
+// Close closes the destination if it's owned by the Writer.
+func (w *Writer) Close() error {
+	if w.c == nil {
+		return nil
+	}
+	return w.c.Close()
+}
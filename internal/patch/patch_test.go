@@ -0,0 +1,86 @@
+package patch
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// write writes a file of 2 placeholder header bytes, patched by Finish.
+func write(t *testing.T, w *Writer) {
+	t.Helper()
+	if w.Started() {
+		t.Fatal("Started before Begin")
+	}
+	if err := w.Begin([]byte("??")); err != nil {
+		t.Fatal(err)
+	}
+	if !w.Started() {
+		t.Fatal("not Started after Begin")
+	}
+	for _, s := range []string{"abc", "def"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Finish([]byte("OK")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	const want = "OKabcdef"
+
+	t.Run("buffered", func(t *testing.T) {
+		var buf bytes.Buffer
+		write(t, New(&buf))
+		if buf.String() != want {
+			t.Errorf("got %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("seekable", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out")
+		w, err := NewFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(t, w)
+		if w.ws == nil {
+			t.Error("file data was buffered")
+		}
+		got, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+
+	t.Run("pipe", func(t *testing.T) {
+		r, pw, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		done := make(chan []byte)
+		go func() {
+			var buf bytes.Buffer
+			buf.ReadFrom(r)
+			done <- buf.Bytes()
+		}()
+
+		// Pipes are *os.File, but fail to seek.
+		w := New(pw)
+		write(t, w)
+		pw.Close()
+		if got := <-done; string(got) != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+// Package pcm reads the chunks and sample data of sound files.
+//
+// Sizes announced by file headers can't be trusted: a file of a few bytes can
+// claim gigabytes of data. The helpers of this package never allocate memory
+// based on such sizes.
+package pcm
+
+import (
+	"errors"
+	"io"
+)
+
+const (
+	// BufSize is the size of the buffer through which Data reads samples, in
+	// bytes.
+	BufSize = 1 << 16
+
+	// MaxPrealloc is the maximum number of samples preallocated by ReadAll.
+	MaxPrealloc = 1 << 16
+)
+
+// ErrTooLarge is returned by ReadChunk for chunks larger than the given limit.
+var ErrTooLarge = errors.New("chunk too large")
+
+// padded returns the size of a chunk body followed by its pad byte, if any.
+func padded(size uint32) int64 {
+	return int64(size) + int64(size&1)
+}
+
+// ReadChunk reads a chunk body of the given size, followed by its pad byte,
+// and returns it without the pad byte. It returns ErrTooLarge, without
+// reading anything, if size is larger than limit.
+func ReadChunk(r io.Reader, size uint32, limit int) ([]byte, error) {
+	if int64(size) > int64(limit) {
+		return nil, ErrTooLarge
+	}
+	b := make([]byte, padded(size))
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b[:size], nil
+}
+
+// SkipChunk discards a chunk body of the given size, followed by its pad byte.
+func SkipChunk(r io.Reader, size uint32) error {
+	_, err := io.CopyN(io.Discard, r, padded(size))
+	return err
+}
+
+// Data reads raw sample data through a buffer of BufSize bytes.
+type Data struct {
+	R      io.Reader
+	Remain int64 // bytes remaining, -1 if unknown, read until EOF
+	buf    []byte
+}
+
+// Read reads the raw bytes of at most n samples of the given size, in bytes.
+// The returned slice is only valid until the next call. It returns io.EOF at
+// the end of data, and io.ErrUnexpectedEOF if data ends before Remain bytes
+// have been read.
+func (d *Data) Read(n, size int) ([]byte, error) {
+	want := int64(min(n, BufSize/size) * size)
+	if d.Remain >= 0 {
+		want = min(want, d.Remain)
+	}
+	if want == 0 {
+		return nil, io.EOF
+	}
+
+	if d.buf == nil {
+		d.buf = make([]byte, BufSize)
+	}
+	b := d.buf[:want]
+
+	got, err := io.ReadFull(d.R, b)
+	if d.Remain >= 0 {
+		d.Remain -= int64(got)
+	}
+	got -= got % size
+	if got > 0 {
+		return b[:got], nil
+	}
+	if err == io.EOF && d.Remain < 0 {
+		return nil, io.EOF
+	}
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+// ReadAll calls read until io.EOF and returns all samples read. count is the
+// number of samples announced by the file, or -1 if unknown. It's only used
+// as a hint, at most MaxPrealloc samples are preallocated.
+func ReadAll[T int16 | float32](read func([]T) (int, error), count int) ([]T, error) {
+	s := make([]T, 0, min(max(count, 1024), MaxPrealloc))
+	for {
+		if len(s) == cap(s) {
+			s = append(s, 0)[:len(s)]
+		}
+		n, err := read(s[len(s):cap(s)])
+		s = s[:len(s)+n]
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return s, err
+		}
+	}
+}
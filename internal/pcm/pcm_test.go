@@ -0,0 +1,102 @@
+package pcm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestReadChunk(t *testing.T) {
+	r := strings.NewReader("abc_def")
+	b, err := ReadChunk(r, 3, 3)
+	if string(b) != "abc" || err != nil {
+		t.Fatalf("ReadChunk = %q, %v, want \"abc\", nil", b, err)
+	}
+	// The pad byte was read too.
+	if _, err := ReadChunk(r, 4, 3); err != ErrTooLarge {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+	if _, err := ReadChunk(r, 4, 4); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	r = strings.NewReader("abc_def")
+	if err := SkipChunk(r, 3); err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 3 {
+		t.Fatalf("%d bytes left after SkipChunk, want 3", r.Len())
+	}
+	if err := SkipChunk(r, math.MaxUint32); err != io.EOF {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestData(t *testing.T) {
+	raw := make([]byte, 3*BufSize)
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+
+	for _, remain := range []int64{int64(len(raw)), -1} {
+		d := Data{R: bytes.NewReader(raw), Remain: remain}
+		var got []byte
+		for {
+			b, err := d.Read(1<<30, 3)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(b)%3 != 0 || len(b) > BufSize {
+				t.Fatalf("Read returned %d bytes", len(b))
+			}
+			got = append(got, b...)
+		}
+		if !bytes.Equal(got, raw) {
+			t.Errorf("remain %d: data mismatch", remain)
+		}
+	}
+
+	// Data ends before the announced size.
+	d := Data{R: bytes.NewReader(raw[:10]), Remain: 1 << 40}
+	if b, err := d.Read(100, 2); len(b) != 10 || err != nil {
+		t.Fatalf("Read = %d bytes, %v, want 10, nil", len(b), err)
+	}
+	if _, err := d.Read(100, 2); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	raw := make([]byte, 2*(MaxPrealloc+1000))
+	for i := range raw {
+		raw[i] = byte(i)
+	}
+	d := Data{R: bytes.NewReader(raw), Remain: -1}
+	read := func(p []int16) (int, error) {
+		b, err := d.Read(len(p), 2)
+		for i := range len(b) / 2 {
+			p[i] = int16(binary.LittleEndian.Uint16(b[2*i:]))
+		}
+		return len(b) / 2, err
+	}
+
+	// The count is only a hint.
+	s, err := ReadAll(read, math.MaxInt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s) != len(raw)/2 {
+		t.Fatalf("read %d samples, want %d", len(s), len(raw)/2)
+	}
+	for i, v := range s {
+		if want := int16(binary.LittleEndian.Uint16(raw[2*i:])); v != want {
+			t.Fatalf("sample %d = %d, want %d", i, v, want)
+		}
+	}
+}
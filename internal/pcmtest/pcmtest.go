@@ -0,0 +1,24 @@
+// Package pcmtest provides sample fixtures and helpers shared by the tests of
+// the sound file packages.
+package pcmtest
+
+import "runtime"
+
+// Samples returns n 16-bit samples of a sawtooth wave, covering most of the
+// 16-bit range.
+func Samples(n int) []int16 {
+	s := make([]int16, n)
+	for i := range s {
+		s[i] = int16(i*37 - 5000)
+	}
+	return s
+}
+
+// Allocated returns the number of bytes allocated while running f.
+func Allocated(f func()) uint64 {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
@@ -0,0 +1,48 @@
+package blip
+
+import "math"
+
+// MixSamples is like ReadSamples, but adds samples to the ones in 'out'
+// rather than overwriting them, after scaling them by gain. Sums are clamped
+// to the 16-bit range. Several buffers can be mixed this way without
+// intermediate slices.
+func (b *Buffer) MixSamples(out []int16, count int, stereo bool, gain float64) int {
+	count, step := b.readCount(len(out), count, stereo)
+	if count == 0 {
+		return 0
+	}
+
+	g := int64(math.Round(gain * (1 << gainBits)))
+	b.integrate(count, func(i, s int) {
+		// The sum can be much wider than what clamp handles.
+		v := int64(out[i*step]) + int64(s)*g>>gainBits
+		out[i*step] = int16(min(max(v, minSample), maxSample))
+	})
+	return count
+}
+
+// MixSamplesInt32 is like MixSamples, adding samples to 32-bit integers,
+// without clamping them.
+func (b *Buffer) MixSamplesInt32(out []int32, count int, stereo bool, gain float64) int {
+	count, step := b.readCount(len(out), count, stereo)
+	if count == 0 {
+		return 0
+	}
+
+	g := int64(math.Round(gain * (1 << gainBits)))
+	b.integrate(count, func(i, s int) { out[i*step] += int32(int64(s) * g >> gainBits) })
+	return count
+}
+
+// MixSamplesFloat32 is like MixSamples, adding samples to floats, the 16-bit
+// range being scaled to [-1, 1), without clamping them.
+func (b *Buffer) MixSamplesFloat32(out []float32, count int, stereo bool, gain float32) int {
+	count, step := b.readCount(len(out), count, stereo)
+	if count == 0 {
+		return 0
+	}
+
+	g := gain / (maxSample + 1)
+	b.integrate(count, func(i, s int) { out[i*step] += float32(s) * g })
+	return count
+}
@@ -0,0 +1,140 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// mixBuffers returns 2 buffers holding different waves of n samples.
+func mixBuffers(n int) (*Buffer, *Buffer) {
+	a := NewBuffer(n)
+	b := NewBuffer(n)
+	squareDeltas(a, n*oversample, 7*oversample+oversample/3, 12000)
+	squareDeltas(b, n*oversample, 5*oversample+oversample/5, -9000)
+	a.EndFrame(n * oversample)
+	b.EndFrame(n * oversample)
+	return a, b
+}
+
+func TestMixSamples(t *testing.T) {
+	const n = 200
+
+	// Reference, unclamped samples.
+	a, b := mixBuffers(n)
+	ra, rb := make([]int32, n), make([]int32, n)
+	a.readRaw(ra)
+	b.readRaw(rb)
+
+	t.Run("int16", func(t *testing.T) {
+		a, b := mixBuffers(n)
+		out := make([]int16, 2*n)
+		assert(t, a.MixSamples(out, n, Stereo, 1), n)
+		assert(t, b.MixSamples(out, n, Stereo, 1), n)
+
+		want := make([]int16, 2*n)
+		for i := range n {
+			want[2*i] = int16(clamp(ra[i] + rb[i]))
+		}
+		if diff := cmp.Diff(out, want); diff != "" {
+			t.Errorf("samples mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("int32", func(t *testing.T) {
+		a, b := mixBuffers(n)
+		out := make([]int32, n)
+		assert(t, a.MixSamplesInt32(out, n, Mono, 2), n)
+		assert(t, b.MixSamplesInt32(out, n, Mono, 0.5), n)
+
+		want := make([]int32, n)
+		for i := range n {
+			want[i] = 2*ra[i] + rb[i]>>1
+		}
+		if diff := cmp.Diff(out, want); diff != "" {
+			t.Errorf("samples mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("float32", func(t *testing.T) {
+		a, b := mixBuffers(n)
+		out := make([]float32, n)
+		assert(t, a.MixSamplesFloat32(out, n, Mono, 1), n)
+		assert(t, b.MixSamplesFloat32(out, n, Mono, 1), n)
+
+		want := make([]float32, n)
+		for i := range n {
+			want[i] = float32(ra[i])/32768 + float32(rb[i])/32768
+		}
+		if diff := cmp.Diff(out, want); diff != "" {
+			t.Errorf("samples mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("matches ReadSamples", func(t *testing.T) {
+		a, _ := mixBuffers(n)
+		c, _ := mixBuffers(n)
+		got := make([]int16, n)
+		want := make([]int16, n)
+		assert(t, a.MixSamples(got, n, Mono, 1), n)
+		c.ReadSamples(want, n, Mono)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("samples mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
+
+func TestMixSamplesSaturation(t *testing.T) {
+	const size = 32
+
+	for _, tt := range []struct {
+		delta int32
+		gain  float64
+		want  int16
+	}{
+		{10000, 4, 32767},
+		{-10000, 4, -32768},
+		{10000, 100, 32767},
+		{-10000, 100, -32768},
+		{10000, 70000, 32767},
+		{-10000, 70000, -32768},
+	} {
+		b := NewBuffer(size)
+		b.AddDeltaFast(0, tt.delta)
+		b.EndFrame(size * oversample)
+
+		// Output already near full scale, on the same side as the delta.
+		out := make([]int16, size)
+		for i := range out {
+			out[i] = 30000
+			if tt.want < 0 {
+				out[i] = -30000
+			}
+		}
+		assert(t, b.MixSamples(out, size, Mono, tt.gain), size)
+		assert(t, out[20], tt.want)
+	}
+}
+
+func TestMixSamplesHold(t *testing.T) {
+	// Reference, samples read with ReadSamples.
+	ref := underrunBuffer(100)
+	ref.SetUnderrunFill(FillHold)
+	want := make([]int16, 101)
+	ref.ReadSamplesFill(want, len(want), Mono)
+
+	// Underruns after mixing hold the last sample of the buffer, not of the
+	// mix.
+	for _, mix := range []func(bl *Buffer){
+		func(bl *Buffer) { bl.MixSamples(make([]int16, 100), 100, Mono, 0.5) },
+		func(bl *Buffer) { bl.MixSamplesInt32(make([]int32, 100), 100, Mono, 0.5) },
+		func(bl *Buffer) { bl.MixSamplesFloat32(make([]float32, 100), 100, Mono, 0.5) },
+	} {
+		bl := underrunBuffer(100)
+		bl.SetUnderrunFill(FillHold)
+		mix(bl)
+		out := make([]int16, 1)
+		assert(t, bl.ReadSamplesFill(out, 1, Mono), 0)
+		assert(t, out[0], want[100])
+	}
+}
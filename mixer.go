@@ -0,0 +1,190 @@
+package blip
+
+import "math"
+
+// gainBits is the number of fractional bits of fixed-point gains.
+const gainBits = 16
+
+// A Source is a Buffer owned by a Mixer. Deltas are added to it and frames are
+// ended in its own clock domain, but its samples must be read through the
+// Mixer.
+type Source struct {
+	buf        *Buffer
+	sampleRate float64 // output sample rate of the mixer
+
+	gain  float64
+	pan   float64
+	mono  int64 // fixed-point gain of mono output
+	left  int64 // fixed-point gain of the left channel
+	right int64 // fixed-point gain of the right channel
+}
+
+// AddDelta adds a delta at the given clock time. See Buffer.AddDelta.
+func (s *Source) AddDelta(time uint64, delta int32) { s.buf.AddDelta(time, delta) }
+
+// AddDeltaFast adds a delta at the given clock time, with lower quality. See
+// Buffer.AddDeltaFast.
+func (s *Source) AddDeltaFast(time uint64, delta int32) { s.buf.AddDeltaFast(time, delta) }
+
+// ClocksNeeded returns the length of time frame, in clocks of the source,
+// needed to make nsamples additional samples available. See
+// Buffer.ClocksNeeded.
+func (s *Source) ClocksNeeded(nsamples int) int { return s.buf.ClocksNeeded(nsamples) }
+
+// EndFrame ends a time frame of the source only. See Buffer.EndFrame.
+func (s *Source) EndFrame(clockDuration int) { s.buf.EndFrame(clockDuration) }
+
+// SetClockRate sets the input clock rate of the source.
+func (s *Source) SetClockRate(clockRate float64) { s.buf.SetRates(clockRate, s.sampleRate) }
+
+// SetGain sets the volume of the source, 1 leaving samples unchanged.
+func (s *Source) SetGain(gain float64) {
+	s.gain = gain
+	s.update()
+}
+
+// SetPan sets the stereo position of the source, from -1 (left) to +1
+// (right). At 0, the default, both channels have full volume. Panning
+// attenuates the opposite channel linearly. Pan is ignored in mono output.
+func (s *Source) SetPan(pan float64) {
+	if pan < -1 || pan > 1 {
+		panic("pan must be in [-1, 1]")
+	}
+	s.pan = pan
+	s.update()
+}
+
+// Gain returns the volume of the source.
+func (s *Source) Gain() float64 { return s.gain }
+
+// Pan returns the stereo position of the source.
+func (s *Source) Pan() float64 { return s.pan }
+
+func (s *Source) update() {
+	const unit = 1 << gainBits
+	s.mono = int64(math.Round(s.gain * unit))
+	s.left = int64(math.Round(s.gain * min(1, 1-s.pan) * unit))
+	s.right = int64(math.Round(s.gain * min(1, 1+s.pan) * unit))
+}
+
+// Mixer mixes several sources, each one having its own clock rate, into a
+// single output sample stream. Sources are mixed before clamping, so that
+// loud sources adding up saturate once rather than each one on its own.
+type Mixer struct {
+	sources    []*Source
+	size       int
+	sampleRate float64
+
+	raw []int32 // unclamped samples of one source
+	acc []int64 // mixed samples
+}
+
+// NewMixer creates a Mixer that can hold at most nsamples samples at the given
+// output sample rate.
+func NewMixer(nsamples int, sampleRate float64) *Mixer {
+	return &Mixer{
+		size:       nsamples,
+		sampleRate: sampleRate,
+	}
+}
+
+// AddSource adds a source with the given input clock rate, unity gain and
+// centered pan.
+func (m *Mixer) AddSource(clockRate float64) *Source {
+	buf := NewBuffer(m.size)
+	buf.SetRates(clockRate, m.sampleRate)
+	s := &Source{buf: buf, sampleRate: m.sampleRate, gain: 1}
+	s.update()
+	m.sources = append(m.sources, s)
+	return s
+}
+
+// Sources returns the sources of the mixer, in the order they were added.
+func (m *Mixer) Sources() []*Source { return m.sources }
+
+// Clear clears all sources.
+func (m *Mixer) Clear() {
+	for _, s := range m.sources {
+		s.buf.Clear()
+	}
+}
+
+// EndFrame ends a time frame in each source, clockDurations holding one
+// duration per source, in the order they were added, and in the clocks of
+// that source. See Buffer.EndFrame.
+func (m *Mixer) EndFrame(clockDurations ...int) {
+	if len(clockDurations) != len(m.sources) {
+		panic("one clock duration per source is required")
+	}
+	for i, s := range m.sources {
+		s.EndFrame(clockDurations[i])
+	}
+}
+
+// SamplesAvailable reports the number of mixed samples available for reading,
+// that is the number of samples available in all sources.
+func (m *Mixer) SamplesAvailable() int {
+	if len(m.sources) == 0 {
+		return 0
+	}
+	avail := m.size
+	for _, s := range m.sources {
+		avail = min(avail, s.buf.avail)
+	}
+	return avail
+}
+
+// ReadSamples reads and removes at most count mixed samples and writes them
+// to 'out'. If stereo is true, each sample is written as an interleaved
+// left/right pair, with sources panned, so 'out' must have room for twice
+// as many values. Returns the number of samples (or pairs) actually read.
+func (m *Mixer) ReadSamples(out []int16, count int, stereo bool) int {
+	if count < 0 {
+		panic("count must be positive")
+	}
+
+	step := 2
+	if !stereo {
+		step = 1
+	}
+	count = min(count, m.SamplesAvailable(), len(out)/step)
+	if count == 0 {
+		return 0
+	}
+
+	if cap(m.raw) < count {
+		m.raw = make([]int32, count)
+		m.acc = make([]int64, 2*count)
+	}
+	raw := m.raw[:count]
+	acc := m.acc[:count*step]
+	clear(acc)
+
+	for _, s := range m.sources {
+		s.buf.readRaw(raw)
+		if stereo {
+			for i, v := range raw {
+				acc[2*i] += int64(v) * s.left
+				acc[2*i+1] += int64(v) * s.right
+			}
+		} else {
+			for i, v := range raw {
+				acc[i] += int64(v) * s.mono
+			}
+		}
+	}
+
+	// Sums can be much wider than what clamp handles.
+	for i, v := range acc {
+		out[i] = int16(min(max(v>>gainBits, minSample), maxSample))
+	}
+	return count
+}
+
+// readRaw reads and removes len(out) samples, without clamping them.
+func (b *Buffer) readRaw(out []int32) {
+	if b.deferred {
+		b.flush()
+	}
+	b.integrate(len(out), func(i, s int) { out[i] = int32(s) })
+}
@@ -0,0 +1,171 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// squareDeltas adds a square wave of the given amplitude and period to bl,
+// for the given number of clocks.
+func squareDeltas(bl interface{ AddDelta(uint64, int32) }, clocks, period int, amp int32) {
+	level := int32(0)
+	for t := 0; t < clocks; t += period {
+		bl.AddDelta(uint64(t), amp-level)
+		level, amp = amp, -amp
+	}
+}
+
+// stepResponse returns the unclamped samples of a buffer holding a single
+// delta at time 0.
+func stepResponse(size int, delta int32) []int32 {
+	bl := NewBuffer(size)
+	bl.AddDeltaFast(0, delta)
+	bl.EndFrame(size * oversample)
+	out := make([]int32, size)
+	bl.readRaw(out)
+	return out
+}
+
+func TestMixerMatchesBuffers(t *testing.T) {
+	const (
+		size       = 1000
+		sampleRate = 44100
+		rateA      = 3579545.45
+		rateB      = 7670453.0
+		frames     = 5
+	)
+
+	mix := NewMixer(size, sampleRate)
+	srcA := mix.AddSource(rateA)
+	srcB := mix.AddSource(rateB)
+
+	bufA := NewBuffer(size)
+	bufA.SetRates(rateA, sampleRate)
+	bufB := NewBuffer(size)
+	bufB.SetRates(rateB, sampleRate)
+
+	var got, wantA, wantB []int16
+	for range frames {
+		clocksA := srcA.ClocksNeeded(735)
+		clocksB := srcB.ClocksNeeded(735)
+		squareDeltas(srcA, clocksA, 1000, 3000)
+		squareDeltas(bufA, clocksA, 1000, 3000)
+		squareDeltas(srcB, clocksB, 1700, -5000)
+		squareDeltas(bufB, clocksB, 1700, -5000)
+
+		mix.EndFrame(clocksA, clocksB)
+		bufA.EndFrame(clocksA)
+		bufB.EndFrame(clocksB)
+
+		n := mix.SamplesAvailable()
+		if n != min(bufA.SamplesAvailable(), bufB.SamplesAvailable()) {
+			t.Fatalf("SamplesAvailable = %d, want %d", n, min(bufA.SamplesAvailable(), bufB.SamplesAvailable()))
+		}
+		out := make([]int16, n)
+		assert(t, mix.ReadSamples(out, n, Mono), n)
+		got = append(got, out...)
+
+		a := make([]int16, n)
+		b := make([]int16, n)
+		bufA.ReadSamples(a, n, Mono)
+		bufB.ReadSamples(b, n, Mono)
+		wantA = append(wantA, a...)
+		wantB = append(wantB, b...)
+	}
+
+	// Amplitudes are low enough for the sum not to clamp.
+	want := make([]int16, len(got))
+	for i := range want {
+		want[i] = wantA[i] + wantB[i]
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("mixed samples mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestMixerSaturation(t *testing.T) {
+	const size = 32
+
+	mix := NewMixer(size, 1)
+	a := mix.AddSource(MaxRatio)
+	b := mix.AddSource(MaxRatio)
+
+	// Each source is beyond the output range, but they cancel out.
+	a.AddDeltaFast(0, 40000)
+	b.AddDeltaFast(0, -30000)
+	mix.EndFrame(size*oversample, size*oversample)
+	var buf [size]int16
+	mix.ReadSamples(buf[:], size, Mono)
+	assert(t, int32(buf[20]), stepResponse(size, 40000)[20]+stepResponse(size, -30000)[20])
+
+	// Together they saturate.
+	mix.Clear()
+	a.AddDeltaFast(0, 20000)
+	b.AddDeltaFast(0, 20000)
+	mix.EndFrame(size*oversample, size*oversample)
+	mix.ReadSamples(buf[:], size, Mono)
+	assert(t, buf[20], 32767)
+
+	// Far beyond full scale.
+	mix = NewMixer(size, 1)
+	srcs := []*Source{mix.AddSource(MaxRatio), mix.AddSource(MaxRatio), mix.AddSource(MaxRatio)}
+	for _, tt := range []struct {
+		delta int32
+		gain  float64
+		want  int16
+	}{
+		{30000, 1, 32767},
+		{-30000, 1, -32768},
+		{30000, 50, 32767},
+		{-30000, 50, -32768},
+		{30000, 20000, 32767},
+	} {
+		mix.Clear()
+		for _, s := range srcs {
+			s.SetGain(tt.gain)
+			s.AddDeltaFast(0, tt.delta)
+		}
+		mix.EndFrame(size*oversample, size*oversample, size*oversample)
+		mix.ReadSamples(buf[:], size, Mono)
+		if buf[20] != tt.want {
+			t.Errorf("3 sources of %d with gain %v = %d, want %d", tt.delta, tt.gain, buf[20], tt.want)
+		}
+	}
+}
+
+func TestMixerGainPan(t *testing.T) {
+	const size = 32
+
+	mix := NewMixer(size, 1)
+	a := mix.AddSource(MaxRatio)
+	b := mix.AddSource(MaxRatio)
+	a.SetGain(0.5)
+	a.SetPan(-1)
+	b.SetPan(0.5)
+
+	a.AddDeltaFast(0, 8000)
+	b.AddDeltaFast(0, 1000)
+	mix.EndFrame(size*oversample, size*oversample)
+
+	var buf [2 * size]int16
+	assert(t, mix.ReadSamples(buf[:], size, Stereo), size)
+	ra := stepResponse(size, 8000)[20]
+	rb := stepResponse(size, 1000)[20]
+	assert(t, int32(buf[2*20]), ra/2+rb/2) // a fully left, b half left
+	assert(t, int32(buf[2*20+1]), rb)      // b fully right
+
+	shouldPanic(t, func() { a.SetPan(1.5) })
+	shouldPanic(t, func() { mix.EndFrame(size * oversample) })
+}
+
+func TestSourceClockRate(t *testing.T) {
+	mix := NewMixer(100, 44100)
+	src := mix.AddSource(44100 * 4)
+	assert(t, src.ClocksNeeded(10), 40)
+	src.SetClockRate(44100 * 2)
+	assert(t, src.ClocksNeeded(10), 20)
+
+	src.EndFrame(20)
+	assert(t, mix.SamplesAvailable(), 10)
+}
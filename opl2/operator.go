@@ -0,0 +1,215 @@
+package opl2
+
+type envState uint8
+
+const (
+	envAttack envState = iota
+	envDecay
+	envSustain
+	envRelease
+)
+
+const (
+	maxAtten = 0x1ff // silent envelope attenuation, 0.1875 dB units
+	zeroSin  = 0x1000
+)
+
+// Key sources, an operator sounds as long as any of them is on.
+const (
+	keyNormal = 1 << iota
+	keyRhythm
+)
+
+// operator is one of the 18 FM operators (slots) of the chip.
+type operator struct {
+	ch *channel
+
+	// Registers
+	am   bool  // tremolo
+	vib  bool  // vibrato
+	egt  bool  // sustained envelope
+	ksr  bool  // key scale rate
+	mult uint8 // frequency multiplier
+	ksl  uint8 // key scale level
+	tl   uint8 // total level
+	ar   uint8 // attack rate
+	dr   uint8 // decay rate
+	sl   uint8 // sustain level
+	rr   uint8 // release rate
+	wf   uint8 // waveform
+
+	key   uint8
+	state envState
+	env   int32  // envelope attenuation
+	phase uint32 // phase accumulator, 10.9 fixed-point
+
+	out   int32  // last output
+	prev  int32  // output before last
+	fbmod int32  // self modulation (feedback)
+	phOut uint32 // 10-bit phase fed to the waveform
+}
+
+func (o *operator) reset() {
+	*o = operator{ch: o.ch, env: maxAtten, state: envRelease}
+}
+
+func (o *operator) keyOn(src uint8) {
+	if o.key == 0 {
+		o.phase = 0
+		o.state = envAttack
+		if o.rate(o.ar) >= 60 {
+			o.env = 0
+			o.state = envDecay
+		}
+	}
+	o.key |= src
+}
+
+func (o *operator) keyOff(src uint8) {
+	if o.key == 0 {
+		return
+	}
+	o.key &^= src
+	if o.key == 0 {
+		o.state = envRelease
+	}
+}
+
+// rate returns the effective envelope rate for the 4-bit rate r, taking key
+// scaling into account.
+func (o *operator) rate(r uint8) uint8 {
+	if r == 0 {
+		return 0
+	}
+	ks := o.ch.keycode()
+	if !o.ksr {
+		ks >>= 2
+	}
+	return min(r*4+ks, 63)
+}
+
+func (o *operator) clockEnvelope(counter uint32) {
+	switch o.state {
+	case envAttack:
+		r := o.rate(o.ar)
+		if r >= 60 {
+			o.env = 0
+		} else if inc := egIncrement(r, counter); inc != 0 {
+			o.env += (^o.env * inc) >> 3
+		}
+		if o.env <= 0 {
+			o.env = 0
+			o.state = envDecay
+		}
+	case envDecay:
+		o.env += egIncrement(o.rate(o.dr), counter)
+		if sl := o.sustainLevel(); o.env >= sl {
+			o.state = envSustain
+		}
+	case envSustain:
+		// Percussive envelopes don't hold the sustain level, they keep
+		// decaying at the release rate while the key is on.
+		if !o.egt {
+			o.env += egIncrement(o.rate(o.rr), counter)
+		}
+	case envRelease:
+		o.env += egIncrement(o.rate(o.rr), counter)
+	}
+	o.env = min(o.env, maxAtten)
+}
+
+func (o *operator) sustainLevel() int32 {
+	if o.sl == 15 {
+		return 31 << 4
+	}
+	return int32(o.sl) << 4
+}
+
+// attenuation returns the total attenuation of the operator: envelope, total
+// level, key scale level and tremolo.
+func (o *operator) attenuation(trem int32) int32 {
+	att := o.env + int32(o.tl)<<2 + o.ch.ksl()>>kslShift[o.ksl]
+	if o.am {
+		att += trem
+	}
+	return min(att, maxAtten)
+}
+
+// clockPhase advances the phase accumulator by one sample. vibPos is the
+// current vibrato position and vibShift the vibrato depth shift.
+func (o *operator) clockPhase(vibPos uint8, vibShift uint) {
+	fnum := int32(o.ch.fnum)
+	if o.vib {
+		r := fnum >> 7 & 7
+		switch {
+		case vibPos&3 == 0:
+			r = 0
+		case vibPos&1 != 0:
+			r >>= 1
+		}
+		r >>= vibShift
+		if vibPos&4 != 0 {
+			r = -r
+		}
+		fnum += r
+	}
+	base := uint32(fnum) << o.ch.block >> 1
+	o.phase += base * multTable[o.mult] >> 1
+	o.phOut = o.phase >> 9
+}
+
+// generate computes the next operator output, with mod added to its phase.
+func (o *operator) generate(mod int32, trem int32) int32 {
+	o.prev = o.out
+	o.out = waveform(o.wf, uint32(int32(o.phOut)+mod), o.attenuation(trem))
+	return o.out
+}
+
+// feedback updates the self-modulation amount from the last 2 outputs.
+func (o *operator) feedback(fb uint8) {
+	if fb == 0 {
+		o.fbmod = 0
+		return
+	}
+	o.fbmod = (o.prev + o.out) >> (9 - fb)
+}
+
+// waveform returns the value of the selected waveform at the given 10-bit
+// phase, attenuated by att.
+func waveform(wf uint8, phase uint32, att int32) int32 {
+	phase &= 0x3ff
+
+	var neg int32
+	level := int32(zeroSin)
+	switch wf {
+	case 0: // sine
+		if phase&0x200 != 0 {
+			neg = -1
+		}
+		level = quarterSin(phase)
+	case 1: // half-sine
+		if phase&0x200 == 0 {
+			level = quarterSin(phase)
+		}
+	case 2: // absolute sine
+		level = quarterSin(phase)
+	case 3: // pulse sine
+		if phase&0x100 == 0 {
+			level = quarterSin(phase)
+		}
+	}
+	return attenuate(level+att<<3) ^ neg
+}
+
+func quarterSin(phase uint32) int32 {
+	if phase&0x100 != 0 {
+		return int32(logSin[phase&0xff^0xff])
+	}
+	return int32(logSin[phase&0xff])
+}
+
+// attenuate converts a logarithmic attenuation to a linear amplitude.
+func attenuate(level int32) int32 {
+	level = min(level, 0x1fff)
+	return int32(exp2[level&0xff]) << 1 >> (level >> 8)
+}
@@ -0,0 +1,466 @@
+// Package opl2 emulates the Yamaha YM3812 (OPL2) FM synthesis chip.
+//
+// The chip runs at its native sample rate, ClockRate/ClocksPerSample (about
+// 49716 Hz). Each native sample is fed into a blip.Buffer as a delta at the
+// input clock it was produced at, so the buffer takes care of resampling to
+// the output sample rate. The buffer clock rate must thus be set to the chip
+// master clock rate:
+//
+//	bl := blip.NewBuffer(sampleRate / 10)
+//	bl.SetRates(opl2.ClockRate, sampleRate)
+//	chip := opl2.NewChip(bl)
+//
+// Like with blip.Buffer, time is expressed in master clocks relative to the
+// beginning of the current time frame. Register writes are timestamped so
+// that the chip can be run up to the time of the write before applying it.
+//
+// The 9 melodic channels, rhythm mode, envelope generator, tremolo and
+// vibrato, waveform select and both timers are emulated. CSM speech synthesis
+// mode is not.
+package opl2
+
+import (
+	"github.com/arl/blip"
+)
+
+const (
+	// ClockRate is the master clock rate of the YM3812, in Hz, as found on
+	// the Adlib and Sound Blaster cards.
+	ClockRate = 3579545
+
+	// ClocksPerSample is the number of master clocks per native sample.
+	ClocksPerSample = 72
+)
+
+// Status register bits.
+const (
+	StatusIRQ    = 0x80 // set when any unmasked timer overflowed
+	StatusTimer1 = 0x40 // timer 1 overflowed
+	StatusTimer2 = 0x20 // timer 2 overflowed
+)
+
+type channel struct {
+	fnum  uint16 // frequency number
+	block uint8  // octave
+	key   bool
+	fb    uint8 // modulator feedback
+	cnt   bool  // additive synthesis (AM) instead of FM
+	nts   *bool // note select, shared by all channels
+}
+
+// keycode returns the 4-bit key code used for envelope rate scaling.
+func (c *channel) keycode() uint8 {
+	bit := c.fnum >> 8 & 1
+	if *c.nts {
+		bit = c.fnum >> 9 & 1
+	}
+	return c.block<<1 | uint8(bit)
+}
+
+// ksl returns the unshifted key scale level attenuation of the channel.
+func (c *channel) ksl() int32 {
+	k := kslTable[c.fnum>>6]<<2 - (8-int32(c.block))<<5
+	return max(k, 0)
+}
+
+// timer is one of the 2 chip timers, counting up from a programmable value
+// until it overflows.
+type timer struct {
+	reload  uint8 // value loaded upon overflow
+	count   int   // current count, overflows at 256
+	period  int   // number of native samples per tick
+	ticks   int   // native samples since last tick
+	running bool
+	masked  bool
+	flag    uint8 // status flag set upon overflow
+}
+
+func (t *timer) clock() (overflow bool) {
+	if !t.running {
+		return false
+	}
+	t.ticks++
+	if t.ticks < t.period {
+		return false
+	}
+	t.ticks = 0
+	t.count++
+	if t.count < 256 {
+		return false
+	}
+	t.count = int(t.reload)
+	return !t.masked
+}
+
+// Chip is an emulated YM3812 that outputs to a blip.Buffer.
+type Chip struct {
+	bl   *blip.Buffer
+	time int // clock time of next native sample
+	amp  int // current amplitude in delta buffer
+
+	addr  uint8 // latched register address
+	regs  [256]uint8
+	ops   [18]operator
+	chans [9]channel
+
+	wse    bool  // waveform select enable
+	nts    bool  // note select
+	rhythm uint8 // rhythm mode register (0xBD)
+
+	samples uint32 // native samples since reset, clocks the LFOs
+	egCount uint32 // envelope generator counter
+	tremPos uint8
+	vibPos  uint8
+	noise   uint32 // rhythm noise LFSR
+
+	timers [2]timer
+	status uint8
+}
+
+// NewChip creates an OPL2 chip whose output is added to bl. bl clock rate
+// should be set to ClockRate.
+func NewChip(bl *blip.Buffer) *Chip {
+	c := &Chip{bl: bl}
+	for i := range c.chans {
+		c.chans[i].nts = &c.nts
+		for _, s := range chanSlots[i] {
+			c.ops[s].ch = &c.chans[i]
+		}
+	}
+	c.Reset()
+	return c
+}
+
+// Reset resets the chip to its power-on state. It doesn't modify the amount
+// already output to the buffer, so the next sample produced brings the output
+// back to silence.
+func (c *Chip) Reset() {
+	for i := range c.ops {
+		c.ops[i].reset()
+	}
+	for i := range c.chans {
+		c.chans[i] = channel{nts: &c.nts}
+	}
+	c.regs = [256]uint8{}
+	c.addr = 0
+	c.wse = false
+	c.nts = false
+	c.rhythm = 0
+	c.samples = 0
+	c.egCount = 0
+	c.tremPos = 0
+	c.vibPos = 0
+	c.noise = 1
+	c.timers = [2]timer{
+		{period: 4, flag: StatusTimer1},  // 80 µs
+		{period: 16, flag: StatusTimer2}, // 320 µs
+	}
+	c.status = 0
+}
+
+// WriteAddr runs the chip up to time and latches the address of the register
+// to be written by the next call to WriteData.
+func (c *Chip) WriteAddr(time int, addr uint8) {
+	c.run(time)
+	c.addr = addr
+}
+
+// WriteData runs the chip up to time and writes data to the register whose
+// address was latched by WriteAddr.
+func (c *Chip) WriteData(time int, data uint8) {
+	c.run(time)
+	c.write(c.addr, data)
+}
+
+// Write runs the chip up to time and writes data to register reg. It's
+// equivalent to WriteAddr followed by WriteData.
+func (c *Chip) Write(time int, reg, data uint8) {
+	c.WriteAddr(time, reg)
+	c.WriteData(time, data)
+}
+
+// ReadStatus runs the chip up to time and returns the status register.
+func (c *Chip) ReadStatus(time int) uint8 {
+	c.run(time)
+	return c.status
+}
+
+// Reg returns the last value written to register reg.
+func (c *Chip) Reg(reg uint8) uint8 {
+	return c.regs[reg]
+}
+
+// EndFrame runs the chip up to endTime and begins a new time frame at
+// endTime. It should be called with the same duration as the EndFrame of the
+// blip.Buffer the chip outputs to.
+func (c *Chip) EndFrame(endTime int) {
+	c.run(endTime)
+	c.time -= endTime
+}
+
+// run generates native samples up to endTime.
+func (c *Chip) run(endTime int) {
+	for ; c.time < endTime; c.time += ClocksPerSample {
+		amp := c.sample()
+		if delta := amp - c.amp; delta != 0 {
+			c.amp = amp
+			c.bl.AddDelta(uint64(c.time), int32(delta))
+		}
+	}
+}
+
+func (c *Chip) write(reg, data uint8) {
+	c.regs[reg] = data
+
+	switch reg & 0xe0 {
+	case 0x00:
+		c.writeControl(reg, data)
+		return
+	case 0xa0, 0xc0:
+		if reg == 0xbd {
+			c.writeRhythm(data)
+			return
+		}
+		if reg&0x0f < 9 {
+			c.writeChannel(reg, data)
+		}
+		return
+	}
+
+	s := slotOfOffset[reg&0x1f]
+	if s < 0 {
+		return
+	}
+	o := &c.ops[s]
+
+	switch reg & 0xe0 {
+	case 0x20:
+		o.am = data&0x80 != 0
+		o.vib = data&0x40 != 0
+		o.egt = data&0x20 != 0
+		o.ksr = data&0x10 != 0
+		o.mult = data & 0x0f
+	case 0x40:
+		o.ksl = data >> 6
+		o.tl = data & 0x3f
+	case 0x60:
+		o.ar = data >> 4
+		o.dr = data & 0x0f
+	case 0x80:
+		o.sl = data >> 4
+		o.rr = data & 0x0f
+	case 0xe0:
+		if c.wse {
+			o.wf = data & 3
+		}
+	}
+}
+
+func (c *Chip) writeControl(reg, data uint8) {
+	switch reg {
+	case 0x01:
+		c.wse = data&0x20 != 0
+		if !c.wse {
+			for i := range c.ops {
+				c.ops[i].wf = 0
+			}
+		}
+	case 0x02:
+		c.timers[0].reload = data
+	case 0x03:
+		c.timers[1].reload = data
+	case 0x04:
+		if data&0x80 != 0 {
+			c.status = 0
+			return
+		}
+		for i, mask := range [2]uint8{0x40, 0x20} {
+			t := &c.timers[i]
+			t.masked = data&mask != 0
+			start := data&(1<<i) != 0
+			if start && !t.running {
+				t.count = int(t.reload)
+				t.ticks = 0
+			}
+			t.running = start
+		}
+	case 0x08:
+		c.nts = data&0x40 != 0
+	}
+}
+
+func (c *Chip) writeChannel(reg, data uint8) {
+	ch := &c.chans[reg&0x0f]
+	switch reg & 0xf0 {
+	case 0xa0:
+		ch.fnum = ch.fnum&0x300 | uint16(data)
+	case 0xb0:
+		ch.fnum = ch.fnum&0xff | uint16(data&3)<<8
+		ch.block = data >> 2 & 7
+		key := data&0x20 != 0
+		if key != ch.key {
+			ch.key = key
+			for _, s := range chanSlots[reg&0x0f] {
+				if key {
+					c.ops[s].keyOn(keyNormal)
+				} else {
+					c.ops[s].keyOff(keyNormal)
+				}
+			}
+		}
+	case 0xc0:
+		ch.fb = data >> 1 & 7
+		ch.cnt = data&1 != 0
+	}
+}
+
+// Rhythm instruments key on bits in register 0xBD, and the slots they key.
+var rhythmKeys = [...]struct {
+	bit   uint8
+	slots []int
+}{
+	{0x10, []int{12, 15}}, // bass drum
+	{0x08, []int{16}},     // snare drum
+	{0x04, []int{14}},     // tom-tom
+	{0x02, []int{17}},     // top cymbal
+	{0x01, []int{13}},     // hi-hat
+}
+
+func (c *Chip) writeRhythm(data uint8) {
+	c.rhythm = data
+	on := data&0x20 != 0
+	for _, rk := range rhythmKeys {
+		for _, s := range rk.slots {
+			if on && data&rk.bit != 0 {
+				c.ops[s].keyOn(keyRhythm)
+			} else {
+				c.ops[s].keyOff(keyRhythm)
+			}
+		}
+	}
+}
+
+// sample generates one native sample.
+func (c *Chip) sample() int {
+	c.clockTimers()
+
+	// Tremolo is a triangle of 210 steps, one step every 64 samples. Vibrato
+	// has 8 steps, one every 1024 samples.
+	if c.samples&63 == 0 {
+		c.tremPos = (c.tremPos + 1) % 210
+	}
+	if c.samples&1023 == 0 {
+		c.vibPos = (c.vibPos + 1) & 7
+	}
+	c.samples++
+
+	trem := int32(c.tremPos)
+	if trem >= 105 {
+		trem = 210 - trem
+	}
+	vibShift := uint(1)
+	if c.rhythm&0x80 != 0 {
+		trem >>= 2
+	} else {
+		trem >>= 4
+	}
+	if c.rhythm&0x40 != 0 {
+		vibShift = 0
+	}
+
+	c.egCount++
+	for i := range c.ops {
+		c.ops[i].clockEnvelope(c.egCount)
+		c.ops[i].clockPhase(c.vibPos, vibShift)
+	}
+
+	rhythm := c.rhythm&0x20 != 0
+	if rhythm {
+		c.rhythmPhases()
+	}
+
+	out := int32(0)
+	nmelodic := len(c.chans)
+	if rhythm {
+		nmelodic = 6
+	}
+	for i := range nmelodic {
+		out += c.channelOutput(i, trem)
+	}
+	if rhythm {
+		out += c.rhythmOutput(trem)
+	}
+
+	// Noise generator is a 23-bit LFSR.
+	bit := (c.noise>>14 ^ c.noise) & 1
+	c.noise = c.noise>>1 | bit<<22
+
+	return int(max(min(out, 32767), -32768))
+}
+
+func (c *Chip) clockTimers() {
+	for i := range c.timers {
+		if c.timers[i].clock() {
+			c.status |= StatusIRQ | c.timers[i].flag
+		}
+	}
+}
+
+// channelOutput returns the output of a 2-operator melodic channel.
+func (c *Chip) channelOutput(i int, trem int32) int32 {
+	ch := &c.chans[i]
+	mod, car := &c.ops[chanSlots[i][0]], &c.ops[chanSlots[i][1]]
+
+	m := mod.generate(mod.fbmod, trem)
+	mod.feedback(ch.fb)
+	if ch.cnt {
+		return m + car.generate(0, trem)
+	}
+	return car.generate(m, trem)
+}
+
+// rhythmPhases overrides the phases of the hi-hat, snare drum and top cymbal
+// operators with the combination of noise and phase bits the chip uses to
+// produce their metallic sound.
+func (c *Chip) rhythmPhases() {
+	hh, sd, tc := &c.ops[13], &c.ops[16], &c.ops[17]
+
+	hhBit := func(n uint) uint32 { return hh.phOut >> n & 1 }
+	tcBit := func(n uint) uint32 { return tc.phOut >> n & 1 }
+	noise := c.noise & 1
+
+	x := (hhBit(2) ^ hhBit(7)) | (hhBit(3) ^ tcBit(5)) | (tcBit(3) ^ tcBit(5))
+
+	hh8 := hhBit(8)
+	hh.phOut = x << 9
+	if x^noise != 0 {
+		hh.phOut |= 0xd0
+	} else {
+		hh.phOut |= 0x34
+	}
+	sd.phOut = hh8<<9 | (hh8^noise)<<8
+	tc.phOut = x<<9 | 0x80
+}
+
+// rhythmOutput returns the output of the rhythm instruments, which replace
+// channels 6 to 8 in rhythm mode.
+func (c *Chip) rhythmOutput(trem int32) int32 {
+	ops := &c.ops
+
+	// Bass drum is a normal 2-operator channel, but for the additive mode
+	// which only outputs the carrier.
+	bd := &c.chans[6]
+	m := ops[12].generate(ops[12].fbmod, trem)
+	ops[12].feedback(bd.fb)
+	if bd.cnt {
+		m = 0
+	}
+	out := ops[15].generate(m, trem)
+
+	// Other instruments are single operators.
+	out += ops[13].generate(0, trem) // hi-hat
+	out += ops[16].generate(0, trem) // snare drum
+	out += ops[14].generate(0, trem) // tom-tom
+	out += ops[17].generate(0, trem) // top cymbal
+	return out * 2
+}
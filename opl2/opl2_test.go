@@ -0,0 +1,179 @@
+package opl2
+
+import (
+	"testing"
+
+	"github.com/arl/blip"
+)
+
+const sampleRate = 44100
+
+func newTestChip() (*Chip, *blip.Buffer) {
+	bl := blip.NewBuffer(sampleRate / 10)
+	bl.SetRates(ClockRate, sampleRate)
+	return NewChip(bl), bl
+}
+
+// render runs the chip for the given number of frames of 1/100 s and returns
+// all generated samples.
+func render(c *Chip, bl *blip.Buffer, frames int) []int16 {
+	const frameLen = ClockRate / 100
+
+	var out []int16
+	buf := make([]int16, sampleRate/10)
+	for range frames {
+		c.EndFrame(frameLen)
+		bl.EndFrame(frameLen)
+		n := bl.ReadSamples(buf, len(buf), blip.Mono)
+		out = append(out, buf[:n]...)
+	}
+	return out
+}
+
+// setupTone programs channel 0 as a plain sine wave of the given frequency
+// number and block.
+func setupTone(c *Chip, fnum uint16, block uint8) {
+	c.Write(0, 0x20, 0x01) // modulator: mult 1
+	c.Write(0, 0x40, 0x3f) // modulator: silent
+	c.Write(0, 0x23, 0x01) // carrier: mult 1
+	c.Write(0, 0x43, 0x00) // carrier: full volume
+	c.Write(0, 0x63, 0xf0) // carrier: instant attack, no decay
+	c.Write(0, 0x83, 0x00) // carrier: sustain at full volume
+	c.Write(0, 0xa0, uint8(fnum))
+	c.Write(0, 0xb0, 0x20|block<<2|uint8(fnum>>8))
+}
+
+func countZeroCrossings(s []int16) int {
+	n := 0
+	for i := 1; i < len(s); i++ {
+		if (s[i-1] < 0) != (s[i] < 0) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestSilence(t *testing.T) {
+	c, bl := newTestChip()
+	for i, s := range render(c, bl, 10) {
+		if s != 0 {
+			t.Fatalf("sample %d = %d, want 0", i, s)
+		}
+	}
+}
+
+func TestTone(t *testing.T) {
+	c, bl := newTestChip()
+
+	// f = fnum * 49716 / 2^(20-block), so fnum 577 at block 4 is ~440 Hz.
+	setupTone(c, 577, 4)
+	out := render(c, bl, 100)
+
+	peak := int16(0)
+	for _, s := range out {
+		peak = max(peak, s)
+	}
+	if peak < 3000 {
+		t.Errorf("peak = %d, want a full volume sine", peak)
+	}
+
+	// One second of a 440 Hz sine crosses zero 880 times.
+	if n := countZeroCrossings(out); n < 870 || n > 890 {
+		t.Errorf("got %d zero crossings, want about 880", n)
+	}
+
+	// Key off with fastest release rate should quickly silence the channel.
+	c.Write(0, 0x83, 0x0f)
+	c.Write(0, 0xb0, 4<<2|uint8(577>>8))
+	out = render(c, bl, 10)
+	for i, s := range out[len(out)/2:] {
+		if s > 16 || s < -16 {
+			t.Fatalf("sample %d = %d after key off, want silence", i, s)
+		}
+	}
+}
+
+func TestWaveformSelect(t *testing.T) {
+	// Half-sine waveform has no negative half. The buffer high-pass filter
+	// removes its DC component though, so it's only much more positive than
+	// it is negative.
+	halfSine := func(wse bool) bool {
+		c, bl := newTestChip()
+		if wse {
+			c.Write(0, 0x01, 0x20)
+		}
+		c.Write(0, 0xe3, 0x01)
+		setupTone(c, 577, 4)
+
+		lo, hi := int16(0), int16(0)
+		for _, s := range render(c, bl, 20) {
+			lo, hi = min(lo, s), max(hi, s)
+		}
+		return -int(lo) < int(hi)/2
+	}
+
+	if halfSine(false) {
+		t.Errorf("waveform selected while disabled")
+	}
+	if !halfSine(true) {
+		t.Errorf("waveform isn't a half-sine")
+	}
+}
+
+func TestRhythm(t *testing.T) {
+	c, bl := newTestChip()
+
+	// Bass drum on channel 6.
+	for _, off := range []uint8{0x10, 0x13} {
+		c.Write(0, 0x20+off, 0x01)
+		c.Write(0, 0x60+off, 0xf4)
+		c.Write(0, 0x80+off, 0x04)
+	}
+	c.Write(0, 0x50, 0x3f)
+	c.Write(0, 0xa6, 0x57)
+	c.Write(0, 0xb6, 0x09)
+
+	// Key on bass drum in rhythm mode.
+	c.Write(0, 0xbd, 0x30)
+
+	active := 0
+	for _, s := range render(c, bl, 10) {
+		if s != 0 {
+			active++
+		}
+	}
+	if active == 0 {
+		t.Fatalf("bass drum is silent")
+	}
+
+	// Leaving rhythm mode keys off the drum.
+	c.Write(0, 0xbd, 0x00)
+	if c.ops[12].key != 0 || c.ops[15].key != 0 {
+		t.Errorf("bass drum operators still keyed on")
+	}
+}
+
+func TestTimers(t *testing.T) {
+	c, _ := newTestChip()
+
+	c.Write(0, 0x04, 0x60) // mask both timers
+	c.Write(0, 0x04, 0x80) // reset flags
+	if s := c.ReadStatus(0); s&0xe0 != 0 {
+		t.Fatalf("status = %#x after reset, want 0", s)
+	}
+
+	// Timer 1 counting from 0xff overflows after a single 80 µs tick.
+	c.Write(0, 0x02, 0xff)
+	c.Write(0, 0x04, 0x21) // start timer 1, mask timer 2
+	if s := c.ReadStatus(ClocksPerSample * 3); s&0xe0 != 0 {
+		t.Fatalf("status = %#x before overflow, want 0", s)
+	}
+	if s := c.ReadStatus(ClocksPerSample * 5); s&0xe0 != StatusIRQ|StatusTimer1 {
+		t.Fatalf("status = %#x after overflow, want %#x", s, StatusIRQ|StatusTimer1)
+	}
+
+	c.Write(ClocksPerSample*5, 0x04, 0x80)
+	if s := c.ReadStatus(ClocksPerSample * 5); s != 0 {
+		t.Fatalf("status = %#x after IRQ reset, want 0", s)
+	}
+}
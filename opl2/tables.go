@@ -0,0 +1,81 @@
+package opl2
+
+import "math"
+
+// logSin holds the attenuation of a quarter sine wave, in 1/256 of a
+// power of two: -log2(sin(x)) * 256.
+var logSin [256]uint16
+
+// exp2 holds the fractional part of the inverse of logSin: 2^(-x/256) scaled
+// to 11 bits.
+var exp2 [256]uint16
+
+func init() {
+	for i := range logSin {
+		s := math.Sin((float64(i) + 0.5) * math.Pi / 512)
+		logSin[i] = uint16(math.Round(-math.Log2(s) * 256))
+	}
+	for i := range exp2 {
+		exp2[i] = uint16(math.Round(math.Exp2(float64(255-i)/256) * 1024))
+	}
+}
+
+// multTable holds twice the frequency multiplier for each MULT value.
+var multTable = [16]uint32{1, 2, 4, 6, 8, 10, 12, 14, 16, 18, 20, 20, 24, 24, 30, 30}
+
+// kslTable holds the key scale level attenuation for the top 4 bits of the
+// frequency number, in 0.75 dB units at block 7.
+var kslTable = [16]int32{0, 32, 40, 45, 48, 51, 53, 55, 56, 58, 59, 60, 61, 62, 63, 64}
+
+// kslShift maps the KSL register value to the shift applied to the key scale
+// level (off, 3 dB/oct, 1.5 dB/oct, 6 dB/oct).
+var kslShift = [4]uint{8, 1, 2, 0}
+
+// egIncTable holds, for each effective rate, 8 attenuation increments packed
+// as nibbles, that are cycled through as the envelope counter advances.
+var egIncTable = [64]uint32{
+	0x00000000, 0x00000000, 0x00000000, 0x00000000,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x10101010, 0x10111010, 0x11101110, 0x11111110,
+	0x11111111, 0x21112111, 0x21212121, 0x22212221,
+	0x22222222, 0x42224222, 0x42424242, 0x44424442,
+	0x44444444, 0x84448444, 0x84848484, 0x88848884,
+	0x88888888, 0x88888888, 0x88888888, 0x88888888,
+}
+
+// egIncrement returns the attenuation increment for the given effective rate
+// at the given envelope counter value.
+func egIncrement(rate uint8, counter uint32) int32 {
+	shift := uint(rate >> 2)
+	c := counter << shift
+	if c&0x7ff != 0 {
+		return 0
+	}
+	idx := c >> max(11, shift) & 7
+	return int32(egIncTable[rate] >> (4 * idx) & 0xf)
+}
+
+// slotOfOffset maps the low 5 bits of an operator register address to an
+// operator slot, or -1 if the address doesn't select any operator.
+var slotOfOffset = [32]int8{
+	0, 1, 2, 3, 4, 5, -1, -1,
+	6, 7, 8, 9, 10, 11, -1, -1,
+	12, 13, 14, 15, 16, 17, -1, -1,
+	-1, -1, -1, -1, -1, -1, -1, -1,
+}
+
+// chanSlots holds the modulator and carrier slots of each channel.
+var chanSlots = [9][2]int{
+	{0, 3}, {1, 4}, {2, 5},
+	{6, 9}, {7, 10}, {8, 11},
+	{12, 15}, {13, 16}, {14, 17},
+}
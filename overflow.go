@@ -0,0 +1,117 @@
+package blip
+
+import "math"
+
+// OverflowPolicy tells a Buffer what to do when a time frame doesn't fit in
+// it, usually because samples aren't read fast enough.
+type OverflowPolicy int
+
+const (
+	// OverflowPanic panics, this is the default.
+	OverflowPanic OverflowPolicy = iota
+
+	// OverflowDropOldest drops the oldest samples available for reading to
+	// make room for new ones.
+	OverflowDropOldest
+
+	// OverflowDropNewest drops the time frame that doesn't fit. Deltas of
+	// that frame are lost, which may shift the output level until the
+	// high-pass filter catches up.
+	OverflowDropNewest
+
+	// OverflowGrow grows the sample storage of the buffer. Note that
+	// ClocksNeeded can't compute the duration of more than MaxFrame
+	// samples.
+	OverflowGrow
+
+	// OverflowDrain calls the drain function set with SetDrainFunc, which
+	// must read samples out of the buffer to make room for new ones. It
+	// panics if the drain function doesn't make enough room.
+	OverflowDrain
+)
+
+// OverflowStats counts the overflow events of a Buffer.
+type OverflowStats struct {
+	Overflows      int // times the buffer was full
+	DroppedSamples int // samples dropped by OverflowDropOldest
+	DroppedFrames  int // time frames dropped by OverflowDropNewest
+	Grows          int // storage growths by OverflowGrow
+	Drains         int // calls to the drain function by OverflowDrain
+}
+
+// SetOverflowPolicy sets what to do when the buffer is full.
+func (b *Buffer) SetOverflowPolicy(p OverflowPolicy) {
+	b.overflow = p
+}
+
+// SetDrainFunc sets the function called by the OverflowDrain policy.
+func (b *Buffer) SetDrainFunc(fn func(*Buffer)) {
+	b.drain = fn
+}
+
+// OverflowStats returns the counts of overflow events since the buffer was
+// created.
+func (b *Buffer) OverflowStats() OverflowStats {
+	return b.stats
+}
+
+// reserve makes room for n more available samples according to the overflow
+// policy. It returns false if the samples don't fit.
+func (b *Buffer) reserve(n int) bool {
+	if b.avail+n <= b.size {
+		return true
+	}
+	b.stats.Overflows++
+
+	switch b.overflow {
+	case OverflowDropOldest:
+		d := min(b.avail+n-b.size, b.avail)
+		b.skip(d)
+		b.stats.DroppedSamples += d
+	case OverflowGrow:
+		b.realloc(max(b.avail+n, 2*b.size))
+		b.stats.Grows++
+	case OverflowDrain:
+		if b.drain == nil {
+			panic("drain function not set")
+		}
+		b.drain(b)
+		b.stats.Drains++
+	}
+	return b.avail+n <= b.size
+}
+
+// reserveDelta makes room for a delta at the given sample position of the
+// current time frame. It panics if the delta doesn't fit, or returns false if
+// it must be dropped.
+func (b *Buffer) reserveDelta(pos uint64) bool {
+	if pos > math.MaxInt32 {
+		panic("buffer exceeded")
+	}
+	if b.overflow == OverflowDropNewest {
+		// The frame will be dropped, and counted, by EndFrame.
+		return false
+	}
+	if !b.reserve(int(pos) - endFrameExtra) {
+		panic("buffer exceeded")
+	}
+	return true
+}
+
+// skip removes count samples, keeping the integrator in sync.
+func (b *Buffer) skip(count int) {
+	b.integrate(count, func(int, int) {})
+}
+
+// realloc reallocates the sample storage to hold size samples.
+func (b *Buffer) realloc(size int) {
+	samples := make([]int32, size+bufExtra)
+	copy(samples, b.samples)
+	b.samples = samples
+	if b.ramp != nil {
+		ramp := make([]int64, size+bufExtra+1)
+		copy(ramp, b.ramp)
+		b.ramp = ramp
+	}
+	b.size = size
+}
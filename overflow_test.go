@@ -0,0 +1,132 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// overflowFrame adds the deltas of frame f to bl and ends the frame, which
+// lasts 30 samples.
+func overflowFrame(bl *Buffer, f int) {
+	const frameLen = 30 * oversample
+	for i := range 5 {
+		delta := int32(1000 + 100*f)
+		if (f+i)%2 == 1 {
+			delta = -delta
+		}
+		bl.AddDelta(uint64(i*frameLen/5+f*oversample/7), delta)
+	}
+	bl.EndFrame(frameLen)
+}
+
+// overflowReference returns the samples of n frames, read out of a buffer big
+// enough not to overflow.
+func overflowReference(n int) []int16 {
+	bl := NewBuffer(n*30 + 10)
+	for f := range n {
+		overflowFrame(bl, f)
+	}
+	return readAll(bl)
+}
+
+func TestOverflowPanic(t *testing.T) {
+	bl := NewBuffer(100)
+	overflowFrame(bl, 0)
+	overflowFrame(bl, 1)
+	overflowFrame(bl, 2)
+	shouldPanic(t, func() { overflowFrame(bl, 3) })
+	assert(t, bl.OverflowStats().Overflows, 1)
+}
+
+func TestOverflowDropOldest(t *testing.T) {
+	const frames = 10
+	want := overflowReference(frames)
+
+	bl := NewBuffer(100)
+	bl.SetOverflowPolicy(OverflowDropOldest)
+	for f := range frames {
+		overflowFrame(bl, f)
+	}
+
+	// The integrator ran over dropped samples, so the newest samples are
+	// exactly those of the reference.
+	got := readAll(bl)
+	assert(t, len(got), 100)
+	if diff := cmp.Diff(got, want[len(want)-100:]); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+	stats := bl.OverflowStats()
+	assert(t, stats.DroppedSamples, len(want)-100)
+	assert(t, stats.Overflows > 0, true)
+}
+
+func TestOverflowDropNewest(t *testing.T) {
+	bl := NewBuffer(100)
+	bl.SetOverflowPolicy(OverflowDropNewest)
+	for f := range 10 {
+		overflowFrame(bl, f)
+	}
+	assert(t, bl.SamplesAvailable(), 90)
+	assert(t, bl.SamplesProduced(), 90)
+
+	want := overflowReference(3)
+	if diff := cmp.Diff(readAll(bl), want); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+	stats := bl.OverflowStats()
+	assert(t, stats.DroppedFrames, 7)
+	assert(t, stats.Overflows, 7)
+}
+
+func TestOverflowGrow(t *testing.T) {
+	const frames = 10
+	want := overflowReference(frames)
+
+	bl := NewBuffer(100)
+	bl.SetOverflowPolicy(OverflowGrow)
+	for f := range frames {
+		overflowFrame(bl, f)
+	}
+	if diff := cmp.Diff(readAll(bl), want); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+	assert(t, bl.OverflowStats().Grows, 2) // 100 -> 200 -> 400
+}
+
+func TestOverflowDrain(t *testing.T) {
+	const frames = 20
+	want := overflowReference(frames)
+
+	var got []int16
+	drain := func(b *Buffer) {
+		got = append(got, readAll(b)...)
+	}
+
+	// Small buffers overflow while deltas are added, not only on EndFrame.
+	for _, size := range []int{40, 50, 100} {
+		got = nil
+		bl := NewBuffer(size)
+		bl.SetOverflowPolicy(OverflowDrain)
+		bl.SetDrainFunc(drain)
+		for f := range frames {
+			overflowFrame(bl, f)
+		}
+		drain(bl)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("size %d: samples mismatch (-got +want):\n%s", size, diff)
+		}
+		if bl.OverflowStats().Drains == 0 {
+			t.Errorf("size %d: drain function wasn't called", size)
+		}
+	}
+
+	bl := NewBuffer(100)
+	bl.SetOverflowPolicy(OverflowDrain)
+	bl.SetDrainFunc(func(*Buffer) {})
+	shouldPanic(t, func() {
+		for f := range 4 {
+			overflowFrame(bl, f)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package blip
+
+// KernelDelay is the delay, in output samples, between the position of a
+// delta and the middle of the band-limited step it produces.
+const KernelDelay = halfWidth - 0.5
+
+// SamplesProduced returns the number of samples made available since the
+// buffer was created or cleared, including those already read. Output
+// samples are indexed from 0 in that order.
+func (b *Buffer) SamplesProduced() uint64 {
+	return b.produced
+}
+
+// FrameStart returns the absolute clock time of the beginning of the current
+// time frame, that is the sum of all time frame durations since the buffer was
+// created or cleared. Fractions of clocks from EndFrameFrac are truncated.
+func (b *Buffer) FrameStart() uint64 {
+	return b.frameStart
+}
+
+// frameStartClock returns the absolute clock time of the beginning of the current
+// time frame, including fractions of clocks.
+func (b *Buffer) frameStartClock() float64 {
+	return float64(b.frameStart) + float64(b.frameFrac)/FracUnit
+}
+
+// SampleAt returns the index of the output sample, possibly fractional, at
+// which a delta added at the given absolute clock time is heard, that is
+// where the middle of its band-limited step lies, kernel delay included.
+//
+// Conversions use the current rates, so they're exact for clock times of the
+// current time frame, after its last rate change if any, and only
+// approximate for clock times before a rate change.
+func (b *Buffer) SampleAt(clock uint64) float64 {
+	rel := float64(int64(clock-b.frameStart)) - float64(b.frameFrac)/FracUnit
+	pos := (rel*float64(b.factor) + float64(int64(b.offset))) / float64(timeUnit)
+	return float64(b.produced) + pos + KernelDelay
+}
+
+// ClockAt is the inverse of SampleAt. It returns the absolute clock time,
+// possibly fractional, of a delta heard at the given output sample index.
+func (b *Buffer) ClockAt(sample float64) float64 {
+	pos := (sample - KernelDelay - float64(b.produced)) * float64(timeUnit)
+	return b.frameStartClock() + (pos-float64(int64(b.offset)))/float64(b.factor)
+}
@@ -0,0 +1,74 @@
+package blip
+
+import (
+	"math"
+	"testing"
+)
+
+func TestFrameStart(t *testing.T) {
+	bl := NewBuffer(1000)
+	bl.SetRates(1789773, 44100)
+
+	bl.EndFrame(10000)
+	bl.EndFrameFrac(10000<<FracBits + FracUnit/2)
+	bl.EndFrameFrac(5000<<FracBits + FracUnit/2)
+	assert(t, bl.FrameStart(), 25001)
+	assert(t, bl.SamplesProduced(), uint64(bl.SamplesAvailable()))
+
+	n := bl.ReadSamples(make([]int16, 100), 100, Mono)
+	bl.EndFrame(1000)
+	assert(t, bl.SamplesProduced(), uint64(n+bl.SamplesAvailable()))
+
+	bl.Clear()
+	assert(t, bl.FrameStart(), 0)
+	assert(t, bl.SamplesProduced(), 0)
+}
+
+// stepCrossing returns the fractional index of the sample at which out
+// crosses the middle of a step of amp, starting from out[0].
+func stepCrossing(out []int16, amp int) float64 {
+	mid := int(out[0]) + amp/2
+	for k := 1; k < len(out); k++ {
+		if (amp > 0) == (int(out[k]) >= mid) {
+			return float64(k-1) + float64(mid-int(out[k-1]))/float64(int(out[k])-int(out[k-1]))
+		}
+	}
+	return math.NaN()
+}
+
+func TestSampleAt(t *testing.T) {
+	const clockRate = 1789773
+	bl := NewBuffer(MaxFrame)
+	bl.SetRates(clockRate, 44100)
+
+	var out []int16
+	buf := make([]int16, MaxFrame)
+	var clocks []uint64
+	var want []float64
+	amp := 16384
+	for f := range 10 {
+		// A single step per frame, of alternate signs.
+		time := uint64(1000 + 3571*f)
+		clocks = append(clocks, bl.FrameStart()+time)
+		want = append(want, bl.SampleAt(bl.FrameStart()+time))
+
+		bl.AddDelta(time, int32(amp))
+		bl.EndFrame(80000)
+		n := bl.ReadSamples(buf, MaxFrame, Mono)
+		out = append(out, buf[:n]...)
+		amp = -amp
+	}
+
+	amp = 16384
+	for i, s := range want {
+		start := int(s) - halfWidth - 2
+		got := float64(start) + stepCrossing(out[start:], amp)
+		if math.Abs(got-s) > 0.1 {
+			t.Errorf("step %d: heard at sample %.3f, SampleAt = %.3f", i, got, s)
+		}
+		if c := bl.ClockAt(bl.SampleAt(clocks[i])); math.Abs(c-float64(clocks[i])) > 1e-3 {
+			t.Errorf("ClockAt(SampleAt(%d)) = %v", clocks[i], c)
+		}
+		amp = -amp
+	}
+}
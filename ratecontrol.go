@@ -0,0 +1,75 @@
+package blip
+
+// DefaultMaxDeviation is the default maximum relative adjustment of the output
+// sample rate by a RateController, small enough not to be heard as a change of
+// pitch.
+const DefaultMaxDeviation = 0.005
+
+// rateSmoothing is the weight of the latest fill level in the smoothed fill
+// level of a RateController.
+const rateSmoothing = 1.0 / 16
+
+// RateController compensates for the drift between the emulated clock and the
+// audio device consuming samples.
+//
+// Emulators usually run in sync with video, so the audio device consumes
+// samples slightly faster or slower than the sample rate passed to SetRates
+// assumes, eventually emptying or overflowing the Buffer. A RateController
+// watches the number of samples available after each time frame and nudges
+// the output sample rate of the Buffer, within a small deviation, to keep it
+// around a target level. Rates are changed without clearing the Buffer, so
+// output stays continuous.
+type RateController struct {
+	buf        *Buffer
+	clockRate  float64
+	sampleRate float64
+	target     float64
+	maxDev     float64
+	fill       float64 // smoothed fill level
+	adjust     float64 // current relative adjustment of sampleRate
+}
+
+// NewRateController sets the rates of b and returns a RateController keeping
+// the number of samples available after each time frame around target.
+func NewRateController(b *Buffer, clockRate, sampleRate float64, target int) *RateController {
+	if target <= 0 || target > b.size {
+		panic("target must be in (0, buffer size]")
+	}
+	rc := &RateController{
+		buf:        b,
+		clockRate:  clockRate,
+		sampleRate: sampleRate,
+		target:     float64(target),
+		maxDev:     DefaultMaxDeviation,
+		fill:       float64(target),
+	}
+	b.SetRates(clockRate, sampleRate)
+	return rc
+}
+
+// SetMaxDeviation sets the maximum relative adjustment of the sample rate, for
+// example 0.005 for ±0.5%.
+func (rc *RateController) SetMaxDeviation(d float64) {
+	if d < 0 || d >= 1 {
+		panic("deviation must be in [0, 1)")
+	}
+	rc.maxDev = d
+}
+
+// Update adjusts the sample rate of the Buffer from the number of samples
+// currently available. It must be called once per time frame, after EndFrame
+// and before samples are read.
+func (rc *RateController) Update() {
+	rc.fill += (float64(rc.buf.avail) - rc.fill) * rateSmoothing
+
+	// The adjustment is proportional to the distance to the target: more
+	// samples are generated when the buffer runs low, fewer when it fills up.
+	adjust := (rc.target - rc.fill) / rc.target * rc.maxDev
+	rc.adjust = max(-rc.maxDev, min(rc.maxDev, adjust))
+	rc.buf.SetRates(rc.clockRate, rc.sampleRate*(1+rc.adjust))
+}
+
+// Ratio returns the ratio between the effective and the nominal sample rate.
+func (rc *RateController) Ratio() float64 {
+	return 1 + rc.adjust
+}
@@ -0,0 +1,108 @@
+package blip
+
+import (
+	"math"
+	"testing"
+)
+
+// simulateDrift runs an emulator producing time frames at 60 Hz into a Buffer,
+// and a device consuming samples at sampleRate*(1+drift). It returns the
+// number of samples the device missed, the minimum and maximum fill levels
+// after warm-up, and the largest ratio change between two frames.
+func simulateDrift(t *testing.T, drift float64, control bool) (missed, lo, hi int, jump float64) {
+	t.Helper()
+
+	const (
+		sampleRate = 44100
+		clockRate  = 1789772.727
+		size       = MaxFrame
+		target     = 2000
+		frames     = 60 * 60 * 10 // 10 minutes
+	)
+
+	bl := NewBuffer(size)
+	bl.SetRates(clockRate, sampleRate)
+	var rc *RateController
+	if control {
+		rc = NewRateController(bl, clockRate, sampleRate, target)
+	}
+
+	// Prime the buffer so that it reaches the target level after the first
+	// frame.
+	bl.EndFrame(bl.ClocksNeeded(target - sampleRate/60))
+
+	lo, hi = size, 0
+	var (
+		out   [size]int16
+		owed  float64 // samples the device is waiting for
+		ratio = 1.0
+		clk   float64
+	)
+	for i := range frames {
+		clk += clockRate / 60
+		clocks := int(clk)
+		clk -= float64(clocks)
+
+		if bl.SamplesAvailable()+int(float64(clocks)*sampleRate*1.01/clockRate)+2 > size {
+			t.Fatalf("frame %d: buffer overflow", i)
+		}
+		bl.AddDelta(0, 100)
+		bl.EndFrame(clocks)
+		if rc != nil {
+			rc.Update()
+			jump = max(jump, math.Abs(rc.Ratio()-ratio))
+			ratio = rc.Ratio()
+		}
+
+		if i > 60*60 {
+			lo = min(lo, bl.SamplesAvailable())
+			hi = max(hi, bl.SamplesAvailable())
+		}
+
+		owed += sampleRate * (1 + drift) / 60
+		want := int(owed)
+		owed -= float64(want)
+		got := bl.ReadSamples(out[:], want, Mono)
+		missed += want - got
+	}
+	return missed, lo, hi, jump
+}
+
+func TestRateControllerDrift(t *testing.T) {
+	for _, drift := range []float64{-0.003, -0.001, 0, 0.001, 0.003} {
+		missed, lo, hi, jump := simulateDrift(t, drift, true)
+		if missed != 0 {
+			t.Errorf("drift %v: device missed %d samples", drift, missed)
+		}
+		// The fill level settles away from the target under drift, but
+		// always leaves more than one frame of samples.
+		if lo < 44100/60 || hi > 3500 {
+			t.Errorf("drift %v: fill level in [%d, %d]", drift, lo, hi)
+		}
+		if jump > 2e-4 {
+			t.Errorf("drift %v: ratio jumped by %v between frames", drift, jump)
+		}
+	}
+
+	// Without rate control, the buffer empties.
+	if missed, _, _, _ := simulateDrift(t, 0.003, false); missed == 0 {
+		t.Errorf("device didn't miss samples without rate control")
+	}
+}
+
+func TestRateControllerBounds(t *testing.T) {
+	bl := NewBuffer(1000)
+	rc := NewRateController(bl, 1e6, 44100, 500)
+	rc.SetMaxDeviation(0.002)
+
+	// Empty buffer: maximum speed-up.
+	for range 1000 {
+		rc.Update()
+	}
+	if r := rc.Ratio(); math.Abs(r-1.002) > 1e-9 {
+		t.Errorf("Ratio = %v, want 1.002", r)
+	}
+
+	shouldPanic(t, func() { rc.SetMaxDeviation(1) })
+	shouldPanic(t, func() { NewRateController(bl, 1e6, 44100, 1001) })
+}
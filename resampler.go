@@ -0,0 +1,128 @@
+package blip
+
+import "math/bits"
+
+// Resampler converts PCM sample streams from one sample rate to another.
+//
+// Each input sample is turned into a delta from the previous one, added to a
+// Buffer whose clock rate is the input sample rate. The input is thus treated
+// as a band-limited step (zero-order hold) waveform. The treble response of
+// the Buffer kernel makes it roll off gently: about -1 dB at a tenth of the
+// output sample rate, -3 dB between 0.2 and 0.28 times the output sample
+// rate (around 10 kHz at 44.1 kHz, depending on the input rate), and -6 to
+// -8 dB at 0.4 times the output sample rate. Like all Buffer output, the DC
+// component is removed by the high-pass filter.
+//
+// Multichannel streams are made of interleaved frames, one sample per
+// channel.
+type Resampler struct {
+	bufs []*Buffer
+	last []int32 // last input sample of each channel
+	raw  []int32
+}
+
+// NewResampler creates a Resampler converting frames of the given number of
+// channels from inRate to outRate, holding at most nframes output frames.
+func NewResampler(nframes int, inRate, outRate float64, channels int) *Resampler {
+	if channels < 1 {
+		panic("at least one channel is required")
+	}
+	r := &Resampler{
+		bufs: make([]*Buffer, channels),
+		last: make([]int32, channels),
+		raw:  make([]int32, nframes),
+	}
+	for i := range r.bufs {
+		r.bufs[i] = NewBuffer(nframes)
+		r.bufs[i].SetRates(inRate, outRate)
+	}
+	return r
+}
+
+// Channels returns the number of interleaved channels.
+func (r *Resampler) Channels() int { return len(r.bufs) }
+
+// Clear clears buffered output and resets the input waveform to 0.
+func (r *Resampler) Clear() {
+	for i, b := range r.bufs {
+		b.Clear()
+		r.last[i] = 0
+	}
+}
+
+// FramesAvailable reports the number of output frames available for reading.
+func (r *Resampler) FramesAvailable() int { return r.bufs[0].avail }
+
+// writable returns the number of input frames that can be written in one time
+// frame without exceeding the output buffer size, nor MaxFrame output
+// samples.
+func (r *Resampler) writable() int {
+	b := r.bufs[0]
+	free := uint64(min(b.size-b.avail, MaxFrame))
+
+	// Largest n such that n*factor+offset doesn't reach free+1 samples, in
+	// 128 bits.
+	hi, lo := bits.Mul64(free+1, timeUnit)
+	lo, borrow := bits.Sub64(lo, b.offset+1, 0)
+	n, _ := bits.Div64(hi-borrow, lo, b.factor)
+	return int(n)
+}
+
+// Write resamples interleaved input frames. It returns the number of frames
+// consumed, which is less than the number of frames in 'in' if the output
+// buffer fills up, in which case the remaining frames must be written again
+// after output frames have been read. It panics if len(in) isn't a multiple
+// of the number of channels.
+func (r *Resampler) Write(in []int16) int {
+	nch := len(r.bufs)
+	if len(in)%nch != 0 {
+		panic("partial frame")
+	}
+	total := len(in) / nch
+	done := 0
+	for done < total {
+		n := min(total-done, r.writable())
+		if n == 0 {
+			break
+		}
+		r.write(in[done*nch:], n)
+		done += n
+	}
+	return done
+}
+
+// write resamples n interleaved input frames in one time frame.
+func (r *Resampler) write(in []int16, n int) {
+	nch := len(r.bufs)
+	for c, b := range r.bufs {
+		last := r.last[c]
+		for i := range n {
+			s := int32(in[i*nch+c])
+			if s != last {
+				b.AddDelta(uint64(i), s-last)
+				last = s
+			}
+		}
+		r.last[c] = last
+		b.EndFrame(n)
+	}
+}
+
+// Read reads and removes at most len(out)/Channels() output frames, written
+// as interleaved samples to 'out'. Returns the number of frames actually
+// read.
+func (r *Resampler) Read(out []int16) int {
+	nch := len(r.bufs)
+	n := min(len(out)/nch, r.FramesAvailable())
+	if n == 0 {
+		return 0
+	}
+	raw := r.raw[:n]
+	for c, b := range r.bufs {
+		b.readRaw(raw)
+		for i, s := range raw {
+			out[i*nch+c] = int16(clamp(s))
+		}
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+package blip
+
+import (
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func sineFrames(n, channels int, rate float64, freqs ...float64) []int16 {
+	s := make([]int16, n*channels)
+	for i := range n {
+		for c := range channels {
+			s[i*channels+c] = int16(16000 * math.Sin(2*math.Pi*freqs[c]*float64(i)/rate))
+		}
+	}
+	return s
+}
+
+// resample resamples 'in' by writing it in chunks of the given number of
+// frames.
+func resample(r *Resampler, in []int16, chunk int) []int16 {
+	nch := r.Channels()
+	var out []int16
+	tmp := make([]int16, 300*nch)
+	for len(in) > 0 {
+		n := r.Write(in[:min(len(in), chunk*nch)])
+		in = in[n*nch:]
+		for {
+			m := r.Read(tmp)
+			if m == 0 {
+				break
+			}
+			out = append(out, tmp[:m*nch]...)
+		}
+	}
+	return out
+}
+
+// level returns the level of the frequency freq in s, in dB relative to the
+// amplitude of sineFrames. The first quarter of s is skipped.
+func level(s []int16, rate, freq float64) float64 {
+	s = s[len(s)/4:]
+	var re, im float64
+	for i, v := range s {
+		ph := 2 * math.Pi * freq * float64(i) / rate
+		re += float64(v) * math.Cos(ph)
+		im += float64(v) * math.Sin(ph)
+	}
+	amp := 2 * math.Hypot(re, im) / float64(len(s))
+	return 20 * math.Log10(amp/16000)
+}
+
+func TestResamplerPassband(t *testing.T) {
+	rates := []struct{ in, out float64 }{
+		{49716, 44100},
+		{44100, 48000},
+		{32000, 44100},
+		{96000, 44100},
+		{44100, 44100},
+	}
+	for _, rr := range rates {
+		levelAt := func(f float64) float64 {
+			r := NewResampler(1000, rr.in, rr.out, 1)
+			return level(resample(r, sineFrames(int(rr.in/2), 1, rr.in, f), 1000), rr.out, f)
+		}
+		// Up to 0.4 times the output rate, but below the input Nyquist
+		// frequency.
+		maxf := min(0.4*rr.out, 0.45*rr.in)
+		for f := 100.0; f <= maxf; f += maxf / 16 {
+			db := levelAt(f)
+			lo := -9.0
+			if f <= rr.out/8 {
+				lo = -1.5
+			}
+			if db < lo || db > 0.5 {
+				t.Errorf("%v Hz -> %v Hz: level at %.0f Hz = %.2f dB", rr.in, rr.out, f, db)
+			}
+		}
+
+		// The -3 dB point, as documented.
+		if db := levelAt(0.19 * rr.out); db < -3 {
+			t.Errorf("%v Hz -> %v Hz: level at 0.19 x output rate = %.2f dB", rr.in, rr.out, db)
+		}
+		if db := levelAt(0.29 * rr.out); db > -3 {
+			t.Errorf("%v Hz -> %v Hz: level at 0.29 x output rate = %.2f dB", rr.in, rr.out, db)
+		}
+	}
+}
+
+func TestResamplerAliasing(t *testing.T) {
+	const in, out = 96000, 44100
+	for _, f := range []float64{30000, 35000, 40000, 45000} {
+		r := NewResampler(1000, in, out, 1)
+		got := resample(r, sineFrames(in/2, 1, in, f), 1000)
+		alias := math.Abs(out - f)
+		if db := level(got, out, alias); db > -60 {
+			t.Errorf("%.0f Hz aliases to %.0f Hz at %.2f dB", f, alias, db)
+		}
+	}
+}
+
+func TestResamplerChunks(t *testing.T) {
+	const in, out = 49716, 44100
+	freqs := []float64{440, 1000, 3000}
+	frames := sineFrames(20000, 3, in, freqs...)
+
+	want := resample(NewResampler(4000, in, out, 3), frames, 20000)
+	for _, chunk := range []int{1, 7, 64, 1000} {
+		got := resample(NewResampler(512, in, out, 3), frames, chunk)
+		if diff := cmp.Diff(got, want); diff != "" {
+			t.Errorf("chunk %d: output mismatch (-got +want):\n%s", chunk, diff)
+		}
+	}
+
+	// Each channel is resampled on its own.
+	for c, f := range freqs {
+		mono := resample(NewResampler(4000, in, out, 1), sineFrames(20000, 1, in, f), 20000)
+		for i, s := range mono {
+			if want[i*3+c] != s {
+				t.Fatalf("channel %d differs from mono resampling at frame %d", c, i)
+			}
+		}
+	}
+}
+
+func TestResamplerFull(t *testing.T) {
+	r := NewResampler(100, 44100, 44100, 2)
+	in := make([]int16, 2*500)
+	n := r.Write(in)
+	if n == 0 || n > 100 {
+		t.Fatalf("Write consumed %d frames", n)
+	}
+	assert(t, r.FramesAvailable(), 100)
+	assert(t, r.Write(in), 0)
+
+	shouldPanic(t, func() { r.Write(in[:3]) })
+}
+
+func TestResamplerLarge(t *testing.T) {
+	const frames = 3 * MaxFrame
+	in := sineFrames(frames, 1, 44100, 1000)
+	want := resample(NewResampler(1000, 44100, 48000, 1), in, 500)
+
+	// Written at once, and read at once.
+	r := NewResampler(2*frames, 44100, 48000, 1)
+	assert(t, r.Write(in), frames)
+	got := make([]int16, r.FramesAvailable())
+	assert(t, r.Read(got), len(got))
+	if diff := cmp.Diff(got, want[:len(got)]); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,39 @@
+package sink
+
+import "github.com/arl/blip"
+
+// A Drainer writes the samples of a blip.Buffer to a mono Sink when the
+// buffer overflows. Its Drain method is meant to be passed to
+// blip.Buffer.SetDrainFunc, along with the blip.OverflowDrain policy:
+//
+//	d := sink.NewDrainer(s)
+//	buf.SetOverflowPolicy(blip.OverflowDrain)
+//	buf.SetDrainFunc(d.Drain)
+type Drainer struct {
+	s   Sink
+	err error
+	buf [1024]int16
+}
+
+// NewDrainer returns a Drainer writing to s. It panics if s isn't mono.
+func NewDrainer(s Sink) *Drainer {
+	if s.Format().Channels != 1 {
+		panic("sink: drainer requires a mono sink")
+	}
+	return &Drainer{s: s}
+}
+
+// Drain reads all available samples of b and writes them to the sink. After
+// a write error, samples are still read, so that b makes room, but they're
+// discarded.
+func (d *Drainer) Drain(b *blip.Buffer) {
+	for b.SamplesAvailable() > 0 {
+		n := b.ReadSamples(d.buf[:], len(d.buf), blip.Mono)
+		if d.err == nil {
+			_, d.err = d.s.WriteFrames(d.buf[:n])
+		}
+	}
+}
+
+// Err returns the first error returned by the sink.
+func (d *Drainer) Err() error { return d.err }
@@ -0,0 +1,61 @@
+package sink
+
+import (
+	"io"
+
+	"github.com/arl/blip/audiofile"
+	"github.com/arl/blip/wave"
+)
+
+// An Encoder is a Sink writing frames to a sound file encoder.
+type Encoder struct {
+	enc    audiofile.Encoder
+	format Format
+}
+
+// NewEncoder returns a Sink writing frames to enc, which must have been
+// created with the sample rate of f. The number of channels of enc is set to
+// the one of f.
+func NewEncoder(enc audiofile.Encoder, f Format) *Encoder {
+	checkFormat(f)
+	enc.SetChannels(f.Channels)
+	return &Encoder{enc: enc, format: f}
+}
+
+// NewWave returns a Sink writing a 16-bit PCM wave file to w. Close doesn't
+// close w.
+func NewWave(w io.Writer, f Format) *Encoder {
+	return NewEncoder(wave.NewWriter(w, f.SampleRate), f)
+}
+
+// Create creates a sound file at the given path, in the container matching its
+// extension (see audiofile.Create), and returns a Sink writing to it.
+func Create(path string, f Format) (*Encoder, error) {
+	checkFormat(f)
+	enc, err := audiofile.Create(path, f.SampleRate)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncoder(enc, f), nil
+}
+
+// Encoder returns the underlying sound file encoder.
+func (e *Encoder) Encoder() audiofile.Encoder { return e.enc }
+
+func (e *Encoder) Format() Format { return e.format }
+
+func (e *Encoder) WriteFrames(p []int16) (int, error) {
+	n, err := frames(e.format, p)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := e.enc.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Flush does nothing, sound files are finalized on Close.
+func (e *Encoder) Flush() error { return nil }
+
+func (e *Encoder) Close() error { return e.enc.Close() }
@@ -0,0 +1,67 @@
+// Package sink decouples render loops from the destination of their samples.
+//
+// A render loop reads samples out of a blip.Buffer and writes them to a Sink,
+// which may encode them to a sound file, stream them as raw PCM, keep them in
+// memory or discard them. A Tee writes the same samples to several sinks:
+//
+//	format := sink.Format{SampleRate: 44100, Channels: 1}
+//	wav, _ := sink.Create("out.wav", format)
+//	raw := sink.NewRaw(os.Stdout, format, binary.LittleEndian)
+//	s := sink.NewTee(wav, raw)
+//	defer s.Close()
+//
+//	for ... {
+//		n := buf.ReadSamples(temp[:], len(temp), blip.Mono)
+//		s.WriteFrames(temp[:n])
+//	}
+package sink
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPartialFrame is returned when the number of samples passed to WriteFrames
+// isn't a multiple of the number of channels.
+var ErrPartialFrame = errors.New("sink: partial frame")
+
+// Format describes the samples written to a Sink.
+type Format struct {
+	SampleRate int // frames per second
+	Channels   int // interleaved channels per frame
+}
+
+func (f Format) String() string {
+	return fmt.Sprintf("%d Hz, %d channels", f.SampleRate, f.Channels)
+}
+
+// A Sink consumes frames of interleaved 16-bit signed samples.
+type Sink interface {
+	// Format returns the format of the frames accepted by the sink.
+	Format() Format
+
+	// WriteFrames writes whole frames of interleaved samples. It returns the
+	// number of frames written, and ErrPartialFrame if len(p) isn't a
+	// multiple of the number of channels.
+	WriteFrames(p []int16) (int, error)
+
+	// Flush pushes frames buffered by the sink to its destination.
+	Flush() error
+
+	// Close flushes the sink and finalizes its destination.
+	Close() error
+}
+
+// frames returns the number of whole frames in p.
+func frames(f Format, p []int16) (int, error) {
+	if len(p)%f.Channels != 0 {
+		return 0, ErrPartialFrame
+	}
+	return len(p) / f.Channels, nil
+}
+
+func checkFormat(f Format) {
+	if f.SampleRate <= 0 || f.Channels <= 0 {
+		panic(fmt.Sprintf("sink: invalid format: %v", f))
+	}
+}
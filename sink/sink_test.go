@@ -0,0 +1,187 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/arl/blip"
+	"github.com/arl/blip/internal/pcmtest"
+	"github.com/arl/blip/wave"
+	"github.com/google/go-cmp/cmp"
+)
+
+var stereo = Format{SampleRate: 44100, Channels: 2}
+
+func TestSinks(t *testing.T) {
+	samples := pcmtest.Samples(10000)
+
+	var wav, le, be bytes.Buffer
+	bw := bufio.NewWriter(&be)
+	mem := NewMemory(stereo)
+	null := NewNull(stereo)
+	s := NewTee(
+		NewWave(&wav, stereo),
+		NewRaw(&le, stereo, binary.LittleEndian),
+		NewRaw(bw, stereo, binary.BigEndian),
+		mem,
+		null,
+	)
+	if s.Format() != stereo {
+		t.Errorf("Format = %v, want %v", s.Format(), stereo)
+	}
+	for i := 0; i < len(samples); i += 3000 {
+		n, err := s.WriteFrames(samples[i:min(i+3000, len(samples))])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (min(i+3000, len(samples)) - i) / 2; n != want {
+			t.Errorf("WriteFrames = %d, want %d", n, want)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(mem.Samples(), samples); diff != "" {
+		t.Errorf("Memory samples mismatch (-got +want):\n%s", diff)
+	}
+	if mem.Frames() != len(samples)/2 {
+		t.Errorf("Memory frames = %d, want %d", mem.Frames(), len(samples)/2)
+	}
+	if null.Frames() != len(samples)/2 {
+		t.Errorf("Null frames = %d, want %d", null.Frames(), len(samples)/2)
+	}
+
+	wantLE := make([]byte, 0, 2*len(samples))
+	wantBE := make([]byte, 0, 2*len(samples))
+	for _, v := range samples {
+		wantLE = binary.LittleEndian.AppendUint16(wantLE, uint16(v))
+		wantBE = binary.BigEndian.AppendUint16(wantBE, uint16(v))
+	}
+	if !bytes.Equal(le.Bytes(), wantLE) {
+		t.Errorf("little-endian raw output mismatch")
+	}
+	if !bytes.Equal(be.Bytes(), wantBE) {
+		t.Errorf("big-endian raw output mismatch")
+	}
+
+	r, err := wave.NewReader(bytes.NewReader(wav.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Channels() != 2 || r.SampleRate() != 44100 {
+		t.Errorf("wave format = %d channels at %d Hz", r.Channels(), r.SampleRate())
+	}
+	got, err := r.ReadAllInt16()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, samples); diff != "" {
+		t.Errorf("wave samples mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.flac")
+	s, err := Create(path, stereo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := pcmtest.Samples(4000)
+	if _, err := s.WriteFrames(samples); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Encoder().SampleCount(); got != len(samples) {
+		t.Errorf("SampleCount = %d, want %d", got, len(samples))
+	}
+
+	if _, err := Create(filepath.Join(t.TempDir(), "out.mp3"), stereo); err == nil {
+		t.Errorf("Create with unknown extension succeeded")
+	}
+}
+
+func TestPartialFrame(t *testing.T) {
+	sinks := []Sink{
+		NewWave(io.Discard, stereo),
+		NewRaw(io.Discard, stereo, binary.LittleEndian),
+		NewMemory(stereo),
+		NewNull(stereo),
+		NewTee(NewMemory(stereo)),
+	}
+	for _, s := range sinks {
+		if _, err := s.WriteFrames(make([]int16, 3)); !errors.Is(err, ErrPartialFrame) {
+			t.Errorf("%T.WriteFrames error = %v, want %v", s, err, ErrPartialFrame)
+		}
+	}
+}
+
+type failSink struct {
+	Null
+	err error
+}
+
+func (f *failSink) WriteFrames(p []int16) (int, error) { return 0, f.err }
+func (f *failSink) Close() error                       { return f.err }
+
+func TestTeeErrors(t *testing.T) {
+	errFail := errors.New("fail")
+	mem := NewMemory(stereo)
+	s := NewTee(&failSink{Null: *NewNull(stereo), err: errFail}, mem)
+	if _, err := s.WriteFrames(make([]int16, 4)); err != errFail {
+		t.Errorf("WriteFrames error = %v, want %v", err, errFail)
+	}
+	if err := s.Close(); !errors.Is(err, errFail) {
+		t.Errorf("Close error = %v, want %v", err, errFail)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("NewTee with mismatched formats didn't panic")
+		}
+	}()
+	NewTee(mem, NewMemory(Format{SampleRate: 48000, Channels: 2}))
+}
+
+func TestDrainer(t *testing.T) {
+	mono := Format{SampleRate: 44100, Channels: 1}
+	mem := NewMemory(mono)
+	d := NewDrainer(mem)
+
+	bl := blip.NewBuffer(100)
+	bl.SetOverflowPolicy(blip.OverflowDrain)
+	bl.SetDrainFunc(d.Drain)
+	for range 10 {
+		bl.AddDelta(0, 1000)
+		bl.EndFrame(30 * blip.MaxRatio)
+	}
+	d.Drain(bl)
+	if d.Err() != nil {
+		t.Fatal(d.Err())
+	}
+	assert := func(name string, got, want int) {
+		t.Helper()
+		if got != want {
+			t.Errorf("%s = %d, want %d", name, got, want)
+		}
+	}
+	assert("frames", mem.Frames(), 300)
+	assert("drains", bl.OverflowStats().Drains, 3)
+
+	errFail := errors.New("fail")
+	d = NewDrainer(&failSink{Null: *NewNull(mono), err: errFail})
+	bl.AddDelta(0, 1000)
+	bl.EndFrame(30 * blip.MaxRatio)
+	d.Drain(bl)
+	if d.Err() != errFail {
+		t.Errorf("Err = %v, want %v", d.Err(), errFail)
+	}
+	assert("available", bl.SamplesAvailable(), 0)
+}
@@ -0,0 +1,169 @@
+package sink
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Raw is a Sink writing headerless 16-bit PCM to an io.Writer.
+type Raw struct {
+	w      io.Writer
+	format Format
+	order  binary.ByteOrder
+	buf    [4096]byte
+}
+
+// NewRaw returns a Sink writing samples to w as 16-bit signed integers in the
+// given byte order. If w has a Flush method, such as *bufio.Writer, it's called
+// by Flush and Close. Close doesn't close w.
+func NewRaw(w io.Writer, f Format, order binary.ByteOrder) *Raw {
+	checkFormat(f)
+	return &Raw{w: w, format: f, order: order}
+}
+
+func (r *Raw) Format() Format { return r.format }
+
+func (r *Raw) WriteFrames(p []int16) (int, error) {
+	n, err := frames(r.format, p)
+	if err != nil {
+		return 0, err
+	}
+	for written := 0; written < len(p); {
+		chunk := p[written:min(len(p), written+len(r.buf)/2)]
+		for i, s := range chunk {
+			r.order.PutUint16(r.buf[2*i:], uint16(s))
+		}
+		if _, err := r.w.Write(r.buf[:2*len(chunk)]); err != nil {
+			return written / r.format.Channels, err
+		}
+		written += len(chunk)
+	}
+	return n, nil
+}
+
+func (r *Raw) Flush() error {
+	if f, ok := r.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+func (r *Raw) Close() error { return r.Flush() }
+
+// A Memory is a Sink capturing samples in memory, mostly useful in tests.
+type Memory struct {
+	format  Format
+	samples []int16
+}
+
+// NewMemory returns an empty Memory sink.
+func NewMemory(f Format) *Memory {
+	checkFormat(f)
+	return &Memory{format: f}
+}
+
+func (m *Memory) Format() Format { return m.format }
+
+func (m *Memory) WriteFrames(p []int16) (int, error) {
+	n, err := frames(m.format, p)
+	if err != nil {
+		return 0, err
+	}
+	m.samples = append(m.samples, p...)
+	return n, nil
+}
+
+func (m *Memory) Flush() error { return nil }
+func (m *Memory) Close() error { return nil }
+
+// Samples returns the interleaved samples written so far. The returned slice
+// is only valid until the next call to WriteFrames or Reset.
+func (m *Memory) Samples() []int16 { return m.samples }
+
+// Frames returns the number of frames written so far.
+func (m *Memory) Frames() int { return len(m.samples) / m.format.Channels }
+
+// Reset discards captured samples.
+func (m *Memory) Reset() { m.samples = m.samples[:0] }
+
+// A Null is a Sink discarding samples, mostly useful in benchmarks.
+type Null struct {
+	format Format
+	frames int
+}
+
+// NewNull returns a Null sink.
+func NewNull(f Format) *Null {
+	checkFormat(f)
+	return &Null{format: f}
+}
+
+func (n *Null) Format() Format { return n.format }
+
+func (n *Null) WriteFrames(p []int16) (int, error) {
+	c, err := frames(n.format, p)
+	n.frames += c
+	return c, err
+}
+
+func (n *Null) Flush() error { return nil }
+func (n *Null) Close() error { return nil }
+
+// Frames returns the number of frames written so far.
+func (n *Null) Frames() int { return n.frames }
+
+// A Tee is a Sink writing the same frames to several sinks.
+type Tee struct {
+	sinks []Sink
+}
+
+// NewTee returns a Sink writing frames to all the given sinks. It panics if no
+// sinks are given or if their formats differ.
+func NewTee(sinks ...Sink) *Tee {
+	if len(sinks) == 0 {
+		panic("sink: tee without sinks")
+	}
+	f := sinks[0].Format()
+	for _, s := range sinks[1:] {
+		if s.Format() != f {
+			panic(fmt.Sprintf("sink: tee format mismatch: %v and %v", f, s.Format()))
+		}
+	}
+	return &Tee{sinks: sinks}
+}
+
+func (t *Tee) Format() Format { return t.sinks[0].Format() }
+
+// WriteFrames writes p to each sink in turn, stopping at the first error.
+func (t *Tee) WriteFrames(p []int16) (int, error) {
+	n, err := frames(t.Format(), p)
+	if err != nil {
+		return 0, err
+	}
+	for _, s := range t.sinks {
+		if _, err := s.WriteFrames(p); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+// Flush flushes all sinks, returning their joined errors.
+func (t *Tee) Flush() error {
+	var errs []error
+	for _, s := range t.sinks {
+		errs = append(errs, s.Flush())
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes all sinks, returning their joined errors.
+func (t *Tee) Close() error {
+	var errs []error
+	for _, s := range t.sinks {
+		errs = append(errs, s.Close())
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,115 @@
+package blip
+
+import "math"
+
+// blRamp is the step kernel blStep averaged over one sample, for each phase.
+// Integrating it gives the increments between samples of a band-limited ramp.
+// It's one sample wider than the step kernel.
+var blRamp = makeRamp()
+
+func makeRamp() (ramp [phaseCount + 1][2*halfWidth + 1]int32) {
+	// tap returns the tap i of the step kernel at the given phase, which
+	// can be a whole sample or more.
+	tap := func(phase, i int) int {
+		i -= phase / phaseCount
+		phase %= phaseCount
+		switch {
+		case i < 0 || i >= 2*halfWidth:
+			return 0
+		case i < halfWidth:
+			return int(blStep[phase*halfWidth+i])
+		}
+		return int(blStep[(phaseCount-phase)*halfWidth+2*halfWidth-1-i])
+	}
+
+	for phase := range ramp {
+		// Trapezoidal average over one sample, exact since kernels are
+		// linearly interpolated between phases.
+		total, peak := 0, 0
+		for i := range ramp[phase] {
+			sum := tap(phase, i) + tap(phase+phaseCount, i)
+			for j := 1; j < phaseCount; j++ {
+				sum += 2 * tap(phase+j, i)
+			}
+			ramp[phase][i] = int32(math.Round(float64(sum) / (2 * phaseCount)))
+			total += int(ramp[phase][i])
+			if ramp[phase][i] > ramp[phase][peak] {
+				peak = i
+			}
+		}
+
+		// Like steps, ramps must sum to deltaUnit, or slopes wouldn't
+		// exactly cancel each other.
+		ramp[phase][peak] += int32(deltaUnit - total)
+	}
+	return ramp
+}
+
+// AddSlope changes the slope of the waveform at the specified clock time, by
+// slope amplitude units per clock, as a band-limited ramp (BLAMP).
+//
+// Sawtooth and triangle waves are generated without aliasing by a single call
+// at each corner, rather than by many small deltas. For example, a triangle
+// wave rising from -a to +a in n clocks and falling back in n more clocks has
+// slope changes of -4a/n at its highest points and 4a/n at its lowest points,
+// once the first slope has been set. The slope remains until changed again,
+// across time frames. Deltas can be combined with slopes, for example to
+// reset a sawtooth wave.
+//
+// The slope is converted with the rates set when AddSlope is called, and can't
+// exceed 32767 amplitude units per sample. Slopes aren't supported in
+// deferred mode.
+func (bl *Buffer) AddSlope(time uint64, slope float64) {
+	if bl.deferred {
+		panic("AddSlope isn't supported in deferred mode")
+	}
+	if bl.ramp == nil {
+		bl.ramp = make([]int64, len(bl.samples)+1)
+	}
+
+	fixed := (time*bl.factor + bl.offset) >> preShift
+
+	// Fails if buffer size was exceeded
+	if uint64(bl.avail)+(fixed>>fracBits) > uint64(bl.size)+endFrameExtra {
+		if !bl.reserveDelta(fixed >> fracBits) {
+			return
+		}
+	}
+
+	// Slope in amplitude units per sample, with deltaBits fraction bits.
+	perSample := slope * float64(timeUnit) / float64(bl.factor) * deltaUnit
+	bl.renderSlope(uint64(bl.avail)<<fracBits+fixed, int64(math.Round(perSample)))
+}
+
+// renderSlope adds a slope change at the given position of the ramp storage,
+// in units of 1<<preShift time units.
+func (bl *Buffer) renderSlope(fixed uint64, slope int64) {
+	if bl.synthesis != BandLimited {
+		bl.renderSampledSlope(fixed, slope)
+		return
+	}
+
+	const phaseShift = fracBits - phaseBits
+	phase := fixed >> phaseShift & (phaseCount - 1)
+
+	interp := fixed >> (phaseShift - deltaBits) & (deltaUnit - 1)
+	slope2 := (slope * int64(interp)) >> deltaBits
+	slope -= slope2
+
+	out := bl.ramp[fixed>>fracBits:]
+	bl.written(fixed>>fracBits + 2*halfWidth + 1)
+	for i := range blRamp[phase] {
+		out[i] += int64(blRamp[phase][i])*slope + int64(blRamp[phase+1][i])*slope2
+	}
+}
+
+// integrateSlopes adds the ramps of count samples, starting at the first
+// sample not yet available, to the deltas of these samples.
+func (bl *Buffer) integrateSlopes(count int) {
+	slope := bl.slope
+	for i, d := range bl.ramp[bl.avail : bl.avail+count] {
+		slope += d
+		bl.samples[bl.avail+i] += int32(slope >> deltaBits)
+	}
+	bl.slope = slope
+}
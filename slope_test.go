@@ -0,0 +1,101 @@
+package blip
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddSlope(t *testing.T) {
+	const (
+		clocksPerSample = 64
+		halfPeriod      = 40*clocksPerSample + 13 // in clocks
+		frameLen        = 3*halfPeriod + 100
+		step            = 4 // amplitude units per clock
+	)
+
+	// A triangle wave made of slopes.
+	got := NewBuffer(1000)
+	got.SetRates(44100*clocksPerSample, 44100)
+
+	// The same triangle wave made of a delta per clock.
+	want := NewBuffer(1000)
+	want.SetRates(44100*clocksPerSample, 44100)
+
+	var frame uint64
+	slope := 0
+	var gotOut, wantOut []int16
+	for range 4 {
+		for clock := frame; clock < frame+frameLen; clock++ {
+			dir := 1 - 2*int(clock/halfPeriod%2)
+			if clock%halfPeriod == 0 {
+				got.AddSlope(clock-frame, float64(dir*step-slope))
+				slope = dir * step
+			}
+			want.AddDelta(clock-frame, int32(dir*step))
+		}
+		got.EndFrame(frameLen)
+		want.EndFrame(frameLen)
+		frame += frameLen
+		assert(t, got.SamplesAvailable(), want.SamplesAvailable())
+		gotOut = append(gotOut, readAll(got)...)
+		wantOut = append(wantOut, readAll(want)...)
+	}
+
+	// The staircase is offset by half a delta from the ramp, and differs
+	// slightly at corners.
+	for i := range gotOut {
+		if d := int(gotOut[i]) - int(wantOut[i]); d < -step || d > step {
+			t.Fatalf("sample %d = %d, want about %d", i, gotOut[i], wantOut[i])
+		}
+	}
+}
+
+func TestAddSlopeCancel(t *testing.T) {
+	bl := NewBuffer(1000)
+	bl.SetRates(1789773, 44100)
+
+	// Slopes summing to zero at arbitrary clock times, leave no slope.
+	for i := range uint64(20) {
+		bl.AddSlope(i*1237, 3.7)
+		bl.AddSlope(i*1237+611, -3.7)
+	}
+	bl.EndFrame(30000)
+	assert(t, bl.slope, int64(0))
+
+	shouldPanic(t, func() { bl.SetDeferred(true) })
+	bl.Clear()
+	bl.SetDeferred(true)
+	shouldPanic(t, func() { bl.AddSlope(0, 1) })
+}
+
+func TestAddSlopeDrain(t *testing.T) {
+	const frames = 10
+
+	// frame adds a triangle wave of 30 samples to bl.
+	frame := func(bl *Buffer) {
+		bl.AddSlope(0, 100.0/oversample)
+		bl.AddSlope(10*oversample+oversample/3, -200.0/oversample)
+		bl.AddSlope(25*oversample, 100.0/oversample)
+		bl.EndFrame(30 * oversample)
+	}
+
+	ref := NewBuffer(frames*30 + 10)
+	for range frames {
+		frame(ref)
+	}
+	want := readAll(ref)
+
+	// Small buffers are drained while slopes are added.
+	var got []int16
+	bl := NewBuffer(40)
+	bl.SetOverflowPolicy(OverflowDrain)
+	bl.SetDrainFunc(func(b *Buffer) { got = append(got, readAll(b)...) })
+	for range frames {
+		frame(bl)
+	}
+	got = append(got, readAll(bl)...)
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,59 @@
+package blip
+
+// Synthesis is the way a Buffer turns deltas into output samples.
+type Synthesis int
+
+const (
+	// BandLimited synthesis renders deltas as band-limited steps, without
+	// aliasing. This is the default.
+	BandLimited Synthesis = iota
+
+	// ZeroOrderHold synthesis applies deltas as plain steps at the nearest
+	// output sample, like hardware sampling its output without filtering.
+	// The output has all the aliasing of such hardware.
+	ZeroOrderHold
+
+	// LinearInterp synthesis applies deltas as steps linearly interpolated
+	// between the 2 nearest output samples, like AddDeltaFast does.
+	LinearInterp
+)
+
+// Option is an option of NewBuffer.
+type Option func(*Buffer)
+
+// WithSynthesis sets the synthesis of the buffer, BandLimited by default.
+//
+// Naive synthesis modes render deltas, impulses and slopes added with the
+// same methods, with the same delay, KernelDelay, so that output can be
+// compared sample per sample. Impulses become pulses lasting one sample, and
+// slopes are sampled without filtering.
+func WithSynthesis(s Synthesis) Option {
+	if s < BandLimited || s > LinearInterp {
+		panic("unknown synthesis")
+	}
+	return func(b *Buffer) {
+		b.synthesis = s
+	}
+}
+
+// renderHold is like render, with ZeroOrderHold synthesis.
+func (bl *Buffer) renderHold(fixed uint64, delta int32) {
+	// Round the position to the nearest sample.
+	i := halfWidth - 1 + fixed>>(fracBits-1)&1
+	bl.samples[fixed>>fracBits+i] += delta * deltaUnit
+	bl.written(fixed>>fracBits + i + 1)
+}
+
+// renderSampledSlope is like renderSlope, for naive synthesis modes. The ramp
+// is sampled exactly, being the integral of a step linearly interpolated
+// between the samples around its middle.
+func (bl *Buffer) renderSampledSlope(fixed uint64, slope int64) {
+	interp := fixed>>(fracBits-deltaBits)&(deltaUnit-1) + deltaUnit/2
+	slope2 := slope * int64(interp&(deltaUnit-1))
+
+	i := fixed>>fracBits + halfWidth - 1 + uint64(interp>>deltaBits)
+	out := bl.ramp[i:]
+	bl.written(i + 2)
+	out[0] += slope*deltaUnit - slope2
+	out[1] += slope2
+}
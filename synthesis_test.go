@@ -0,0 +1,86 @@
+package blip
+
+import (
+	"math"
+	"testing"
+)
+
+func TestZeroOrderHold(t *testing.T) {
+	const clocksPerSample = 1000
+
+	bl := NewBuffer(1000, WithSynthesis(ZeroOrderHold))
+	bl.SetRates(44100*clocksPerSample, 44100)
+
+	// Steps at various phases, heard at the nearest sample once delayed.
+	steps := make(map[int]int32)
+	for i := range 20 {
+		time := uint64(i*40*clocksPerSample + i*97)
+		delta := int32(10000 - 20000*(i%2))
+		bl.AddDelta(time, delta)
+
+		// The first sample after the middle of the step.
+		steps[int(math.Ceil(bl.SampleAt(time)))] = delta
+	}
+	bl.EndFrame(820 * clocksPerSample)
+
+	// Apart from steps, the output only decays, because of the high-pass
+	// filter.
+	out := readAll(bl)
+	for i := 1; i < len(out); i++ {
+		d := int32(out[i]) - int32(out[i-1])
+		if want := steps[i]; d < want-30 || d > want+30 {
+			t.Fatalf("sample %d - sample %d = %d, want about %d", i, i-1, d, want)
+		}
+	}
+}
+
+func TestLinearInterp(t *testing.T) {
+	const clocksPerSample = 1000
+
+	bl := NewBuffer(100, WithSynthesis(LinearInterp))
+	bl.SetRates(44100*clocksPerSample, 44100)
+
+	// A step a quarter of a sample after its middle is 3/4 past.
+	const time = 10*clocksPerSample + clocksPerSample/4
+	i := int(bl.SampleAt(time-clocksPerSample/4)) + 1
+	bl.AddDelta(time, 8000)
+	bl.EndFrame(40 * clocksPerSample)
+
+	out := readAll(bl)
+	assert(t, out[i-1], int16(0))
+	assert(t, out[i], int16(6000))
+	if out[i+1] < 7980 || out[i+1] > 8000 {
+		t.Errorf("sample %d = %d, want about 8000", i+1, out[i+1])
+	}
+}
+
+func TestWithSynthesis(t *testing.T) {
+	shouldPanic(t, func() { WithSynthesis(LinearInterp + 1) })
+}
+
+func TestSampledSlope(t *testing.T) {
+	const clocksPerSample = 1000
+
+	bl := NewBuffer(100, WithSynthesis(ZeroOrderHold))
+	bl.SetRates(44100*clocksPerSample, 44100)
+
+	// A ramp of 100 per sample, starting a quarter of a sample before sample
+	// i once delayed.
+	const time = 10*clocksPerSample + clocksPerSample/4
+	i := int(bl.SampleAt(time-clocksPerSample/4)) + 1
+	bl.AddSlope(time, 100.0/clocksPerSample)
+	bl.AddSlope(time+20*clocksPerSample, -100.0/clocksPerSample)
+	bl.EndFrame(40 * clocksPerSample)
+
+	out := readAll(bl)
+	assert(t, out[i-1], int16(0))
+	assert(t, out[i], int16(25))
+
+	// The high-pass filter slowly pulls samples down.
+	if out[i+1] < 123 || out[i+1] > 125 {
+		t.Errorf("sample %d = %d, want about 125", i+1, out[i+1])
+	}
+	if out[i+20] < 1950 || out[i+20] > 2000 {
+		t.Errorf("sample %d = %d, want about 2000", i+20, out[i+20])
+	}
+}
@@ -0,0 +1,116 @@
+package blip
+
+// UnderrunFill tells ReadSamplesFill how to fill output samples missing from
+// the buffer.
+type UnderrunFill int
+
+const (
+	// FillSilence fills missing samples with zeros, this is the default.
+	FillSilence UnderrunFill = iota
+
+	// FillHold holds the last sample value, decaying it exponentially
+	// towards zero.
+	FillHold
+
+	// FillFade fades the last sample value out linearly, over a few
+	// milliseconds, and crossfades back to buffered samples once they're
+	// available again.
+	FillFade
+)
+
+const (
+	// fadeLen is the length in samples of FillFade fades, about 1.5 ms at
+	// 44.1 kHz.
+	fadeLen = 64
+
+	// holdShift sets the decay of FillHold: the held value loses
+	// 1/(1<<holdShift) of itself each sample.
+	holdShift = 8
+)
+
+// underrun is the state of underrun filling.
+type underrun struct {
+	mode    UnderrunFill
+	count   int   // number of underruns
+	last    int16 // last sample read
+	filling bool  // whether the last sample read was filled
+	from    int32 // value at the start of the current fade or crossfade
+	fill    int32 // last filled value
+	pos     int   // position in the current fade
+	resume  int   // remaining samples of the crossfade after an underrun
+}
+
+// SetUnderrunFill sets how ReadSamplesFill fills missing samples.
+func (b *Buffer) SetUnderrunFill(f UnderrunFill) {
+	b.under.mode = f
+}
+
+// Underruns returns the number of calls to ReadSamplesFill that asked for
+// more samples than available.
+func (b *Buffer) Underruns() int {
+	return b.under.count
+}
+
+// ReadSamplesFill is like ReadSamples, but always writes count samples to
+// 'out' (or as many as 'out' can hold), filling the ones missing from the
+// buffer as set by SetUnderrunFill. This lets audio callbacks fill their
+// output entirely. Returns the number of samples actually read from the
+// buffer.
+func (b *Buffer) ReadSamplesFill(out []int16, count int, stereo bool) int {
+	step := 2
+	if !stereo {
+		step = 1
+	}
+	count = min(count, (len(out)+step-1)/step)
+
+	n := b.ReadSamples(out, count, stereo)
+	u := &b.under
+	if n > 0 && u.filling {
+		u.filling = false
+		if u.mode == FillFade {
+			u.from = u.fill
+			u.resume = fadeLen
+		}
+	}
+
+	// Crossfade from the filled value to buffered samples.
+	for i := 0; i < n && u.resume > 0; i++ {
+		k := int32(fadeLen - u.resume)
+		v := (u.from*(fadeLen-k) + int32(out[i*step])*k) / fadeLen
+		out[i*step] = int16(v)
+		u.resume--
+	}
+	if n > 0 {
+		u.last = out[(n-1)*step]
+	}
+
+	if n == count {
+		return n
+	}
+
+	u.count++
+	if !u.filling {
+		u.filling = true
+		u.from = int32(u.last)
+		u.fill = u.from
+		u.pos = 0
+		u.resume = 0
+	}
+	for i := n; i < count; i++ {
+		switch u.mode {
+		case FillSilence:
+			u.fill = 0
+		case FillHold:
+			d := u.fill >> holdShift
+			if d == 0 && u.fill > 0 {
+				d = 1 // small positive values don't decay by shifting
+			}
+			u.fill -= d
+		case FillFade:
+			u.pos = min(u.pos+1, fadeLen)
+			u.fill = u.from * int32(fadeLen-u.pos) / fadeLen
+		}
+		out[i*step] = int16(u.fill)
+	}
+	return n
+}
@@ -0,0 +1,88 @@
+package blip
+
+import "testing"
+
+// underrunBuffer returns a buffer holding a step of 16384 at its beginning,
+// with n samples available.
+func underrunBuffer(n int) *Buffer {
+	bl := NewBuffer(1000)
+	bl.AddDelta(0, 16384)
+	bl.EndFrame(n * oversample)
+	return bl
+}
+
+func TestReadSamplesFillSilence(t *testing.T) {
+	bl := underrunBuffer(100)
+	out := make([]int16, 150)
+	assert(t, bl.ReadSamplesFill(out, 150, Mono), 100)
+	assert(t, out[99] > 10000, true)
+	for _, s := range out[100:] {
+		assert(t, s, 0)
+	}
+	assert(t, bl.Underruns(), 1)
+
+	// No underrun when enough samples are available.
+	bl.EndFrame(10 * oversample)
+	assert(t, bl.ReadSamplesFill(out, 10, Mono), 10)
+	assert(t, bl.Underruns(), 1)
+}
+
+func TestReadSamplesFillHold(t *testing.T) {
+	bl := underrunBuffer(100)
+	bl.SetUnderrunFill(FillHold)
+	out := make([]int16, 5000)
+	assert(t, bl.ReadSamplesFill(out, len(out), Mono), 100)
+
+	// The last value is held, decaying down to zero.
+	if d := out[99] - out[100]; d < 0 || d > out[99]>>holdShift+1 {
+		t.Errorf("hold starts at %d after %d", out[100], out[99])
+	}
+	for i := 101; i < len(out); i++ {
+		if out[i] > out[i-1] || out[i] < 0 {
+			t.Fatalf("hold doesn't decay at %d: %d after %d", i, out[i], out[i-1])
+		}
+	}
+	assert(t, out[len(out)-1], 0)
+}
+
+func TestReadSamplesFillFade(t *testing.T) {
+	const avail = 100
+	want := make([]int16, 2*avail)
+	ref := underrunBuffer(avail)
+	ref.EndFrame(avail * oversample)
+	ref.ReadSamples(want, len(want), Mono)
+
+	bl := underrunBuffer(avail)
+	bl.SetUnderrunFill(FillFade)
+	out := make([]int16, avail+fadeLen/2)
+	assert(t, bl.ReadSamplesFill(out, len(out), Mono), avail)
+
+	// Fade out, halfway through.
+	last := int32(out[avail-1])
+	for i := range fadeLen / 2 {
+		assert(t, int32(out[avail+i]), last*int32(fadeLen-i-1)/fadeLen)
+	}
+
+	// Crossfade back to buffered samples.
+	from := int32(out[len(out)-1])
+	bl.EndFrame(avail * oversample)
+	assert(t, bl.ReadSamplesFill(out, avail, Mono), avail)
+	for i := range fadeLen {
+		w := int32(want[avail+i])
+		assert(t, int32(out[i]), (from*int32(fadeLen-i)+w*int32(i))/fadeLen)
+	}
+	for i := fadeLen; i < avail; i++ {
+		assert(t, out[i], want[avail+i])
+	}
+	assert(t, bl.Underruns(), 1)
+}
+
+func TestReadSamplesFillStereo(t *testing.T) {
+	bl := underrunBuffer(10)
+	out := makefill(40, int16(-1))
+	assert(t, bl.ReadSamplesFill(out, 20, Stereo), 10)
+	for i := range 20 {
+		assert(t, out[2*i+1], -1)
+	}
+	assert(t, out[2*19], 0)
+}
@@ -0,0 +1,60 @@
+package vgm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Tag holds the GD3 tag of a VGM file. Most fields come in an english and a
+// japanese version.
+type Tag struct {
+	Track, TrackJP   string
+	Game, GameJP     string
+	System, SystemJP string
+	Author, AuthorJP string
+	Date             string // release date
+	Ripper           string // person who converted the music to VGM
+	Notes            string
+}
+
+func parseTag(buf []byte) (*Tag, error) {
+	if len(buf) < 12 || string(buf[:4]) != "Gd3 " {
+		return nil, fmt.Errorf("%w: missing GD3 tag", ErrFormat)
+	}
+
+	size := int(binary.LittleEndian.Uint32(buf[8:]))
+	buf = buf[12:]
+	if size > len(buf) {
+		return nil, fmt.Errorf("%w: truncated GD3 tag", ErrFormat)
+	}
+	buf = buf[:size]
+
+	var t Tag
+	fields := [...]*string{
+		&t.Track, &t.TrackJP,
+		&t.Game, &t.GameJP,
+		&t.System, &t.SystemJP,
+		&t.Author, &t.AuthorJP,
+		&t.Date, &t.Ripper, &t.Notes,
+	}
+
+	// Fields are null-terminated UTF-16LE strings.
+	var u []uint16
+	for _, f := range fields {
+		u = u[:0]
+		for {
+			if len(buf) < 2 {
+				return nil, fmt.Errorf("%w: truncated GD3 tag", ErrFormat)
+			}
+			c := binary.LittleEndian.Uint16(buf)
+			buf = buf[2:]
+			if c == 0 {
+				break
+			}
+			u = append(u, c)
+		}
+		*f = string(utf16.Decode(u))
+	}
+	return &t, nil
+}
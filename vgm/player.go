@@ -0,0 +1,169 @@
+package vgm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/arl/blip"
+	"github.com/arl/blip/opl2"
+)
+
+// ErrNoChip is returned when a VGM file uses none of the supported chips.
+var ErrNoChip = errors.New("vgm: no supported chip")
+
+// Player plays a VGM file back into a blip.Buffer.
+//
+// The buffer input clock is the master clock of the chips (see ClockRate). The
+// player converts VGM waits, expressed at SampleRate, to input clocks.
+type Player struct {
+	f  *File
+	bl *blip.Buffer
+
+	clockRate uint64
+	opl       []*opl2.Chip
+
+	pc    int    // offset of next command in f.Data
+	wait  int    // remaining samples to wait before next command
+	pos   uint64 // absolute position, in samples at SampleRate
+	frame uint64 // absolute position of current time frame start
+	loops int    // number of loops to play
+	loop  int    // loops played so far
+	done  bool
+}
+
+// NewPlayer creates a player that renders f into bl. The clock rate of bl
+// must be set to ClockRate().
+func NewPlayer(f *File, bl *blip.Buffer) (*Player, error) {
+	p := &Player{f: f, bl: bl}
+
+	if c := f.Header.YM3812Clock; c != 0 {
+		p.clockRate = uint64(clock(c))
+		p.opl = append(p.opl, opl2.NewChip(bl))
+		if c&DualChip != 0 {
+			p.opl = append(p.opl, opl2.NewChip(bl))
+		}
+	}
+
+	if p.clockRate == 0 {
+		return nil, ErrNoChip
+	}
+	return p, nil
+}
+
+// ClockRate returns the clock rate, in Hz, that the input clock rate of the
+// player buffer must be set to.
+func (p *Player) ClockRate() float64 {
+	return float64(p.clockRate)
+}
+
+// SetLoops sets the number of times the looping part of the file is repeated
+// after the first time it's played. It has no effect on files without a loop.
+func (p *Player) SetLoops(n int) {
+	p.loops = n
+}
+
+// Length returns the total play length in samples at SampleRate, taking the
+// number of loops into account.
+func (p *Player) Length() int {
+	n := int(p.f.Header.TotalSamples)
+	if p.f.LoopOffset >= 0 {
+		n += p.loops * int(p.f.Header.LoopSamples)
+	}
+	return n
+}
+
+// Play plays the next n samples (at SampleRate) of the file and ends a time
+// frame in the buffer, making them available for reading. It returns the
+// number of samples played, which is less than n only at the end of the file,
+// in which case the error is io.EOF.
+func (p *Player) Play(n int) (int, error) {
+	end := p.pos + uint64(n)
+	for p.pos < end {
+		if p.wait > 0 {
+			w := min(uint64(p.wait), end-p.pos)
+			p.wait -= int(w)
+			p.pos += w
+			continue
+		}
+		if p.done {
+			break
+		}
+		if err := p.step(); err != nil {
+			return 0, err
+		}
+	}
+
+	played := n - int(end-p.pos)
+
+	clocks := p.clock(p.pos)
+	for _, c := range p.opl {
+		c.EndFrame(clocks)
+	}
+	p.bl.EndFrame(clocks)
+	p.frame = p.pos
+
+	if p.done && p.wait == 0 {
+		return played, io.EOF
+	}
+	return played, nil
+}
+
+// clock converts an absolute position in samples to a clock time in the
+// current frame.
+func (p *Player) clock(pos uint64) int {
+	return int(pos*p.clockRate/SampleRate - p.frame*p.clockRate/SampleRate)
+}
+
+// step executes the next command.
+func (p *Player) step() error {
+	data := p.f.Data
+	if p.pc >= len(data) {
+		p.end()
+		return nil
+	}
+
+	n, err := cmdLen(data[p.pc:])
+	if err != nil {
+		return err
+	}
+	cmd := data[p.pc : p.pc+n]
+	p.pc += n
+
+	switch op := cmd[0]; {
+	case op == 0x61:
+		p.wait = int(binary.LittleEndian.Uint16(cmd[1:]))
+	case op == 0x62:
+		p.wait = 735
+	case op == 0x63:
+		p.wait = 882
+	case op == 0x66:
+		p.end()
+	case op >= 0x70 && op <= 0x7f:
+		p.wait = int(op&0x0f) + 1
+	case op >= 0x80 && op <= 0x8f:
+		// YM2612 DAC writes aren't emulated, but their wait is.
+		p.wait = int(op & 0x0f)
+	case op == 0x5a:
+		p.writeOPL(0, cmd[1], cmd[2])
+	case op == 0xaa:
+		p.writeOPL(1, cmd[1], cmd[2])
+	}
+	return nil
+}
+
+// end handles the end of the command stream, looping if needed.
+func (p *Player) end() {
+	if p.loop < p.loops && p.f.LoopOffset >= 0 {
+		p.loop++
+		p.pc = p.f.LoopOffset
+		return
+	}
+	p.done = true
+}
+
+func (p *Player) writeOPL(i int, reg, data uint8) {
+	if i < len(p.opl) {
+		p.opl[i].Write(p.clock(p.pos), reg, data)
+	}
+}
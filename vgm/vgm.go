@@ -0,0 +1,261 @@
+// Package vgm parses VGM (Video Game Music) files and plays them back through
+// the chip emulators provided by blip.
+//
+// VGM files are logs of the register writes sent to sound chips, interleaved
+// with waits expressed in samples of a 44100 Hz clock. Gzip-compressed files
+// (VGZ) are transparently decompressed.
+//
+// Only the chips emulated by blip are played back, writes to other chips are
+// ignored. Currently supported chips are:
+//   - YM3812 (OPL2), including dual chip configurations.
+package vgm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// SampleRate is the rate of the clock VGM waits are expressed in.
+const SampleRate = 44100
+
+// ErrFormat is returned when parsing data that is not a valid VGM file.
+var ErrFormat = errors.New("vgm: invalid format")
+
+// Header holds the fields of a VGM file header that are relevant for playback.
+type Header struct {
+	Version      uint32 // BCD version number, e.g. 0x171 for 1.71
+	TotalSamples uint32 // total number of samples, at SampleRate
+	LoopSamples  uint32 // number of samples in the loop, 0 if no loop
+	Rate         uint32 // recording rate of the system (i.e. 50 or 60 Hz), 0 if unknown
+
+	SN76489Clock uint32
+	YM2413Clock  uint32
+	YM2612Clock  uint32
+	YM2151Clock  uint32
+	YM3812Clock  uint32 // bit 30 is set for dual chip configurations
+	YM3526Clock  uint32
+	Y8950Clock   uint32
+}
+
+// DualChip is the bit set in a chip clock field of the header when 2 chips of
+// that type are present.
+const DualChip = 1 << 30
+
+// clock returns the clock of a chip, stripped of flags.
+func clock(v uint32) uint32 {
+	return v &^ (DualChip | 1<<31)
+}
+
+// A DataBlock holds data to be transferred to a chip, such as PCM samples or
+// ROM contents.
+type DataBlock struct {
+	Type uint8
+	Data []byte
+}
+
+// File is a parsed VGM file.
+type File struct {
+	Header Header
+
+	// Tag holds the GD3 tag. It's nil if the file has no tag.
+	Tag *Tag
+
+	// Data is the command stream.
+	Data []byte
+
+	// LoopOffset is the offset in Data of the loop start, or -1 if the file
+	// doesn't loop.
+	LoopOffset int
+
+	// Blocks holds the data blocks found in the command stream, in order.
+	Blocks []DataBlock
+}
+
+// Open reads and parses the VGM or VGZ file at path.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads and parses a VGM file from r. Gzip-compressed data is
+// decompressed first.
+func Parse(r io.Reader) (*File, error) {
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) >= 2 && buf[0] == 0x1f && buf[1] == 0x8b {
+		zr, err := gzip.NewReader(bytes.NewReader(buf))
+		if err != nil {
+			return nil, err
+		}
+		if buf, err = io.ReadAll(zr); err != nil {
+			return nil, err
+		}
+	}
+	return parse(buf)
+}
+
+func parse(buf []byte) (*File, error) {
+	if len(buf) < 0x40 || string(buf[:4]) != "Vgm " {
+		return nil, ErrFormat
+	}
+
+	u32 := func(off int) uint32 { return binary.LittleEndian.Uint32(buf[off:]) }
+
+	// Offsets stored in the header are relative to their own position.
+	reloff := func(off int) int {
+		if v := u32(off); v != 0 {
+			return off + int(v)
+		}
+		return 0
+	}
+
+	f := &File{LoopOffset: -1}
+	h := &f.Header
+	h.Version = u32(0x08)
+
+	end := len(buf)
+	if eof := reloff(0x04); eof != 0 && eof < end {
+		end = eof
+	}
+
+	start := 0x40
+	if h.Version >= 0x150 {
+		if off := reloff(0x34); off != 0 {
+			start = off
+		}
+	}
+	if start > end {
+		return nil, fmt.Errorf("%w: data offset %#x past end of file", ErrFormat, start)
+	}
+
+	// Header fields located at or past the start of data are not part of the
+	// header and must be read as 0.
+	field := func(off int) uint32 {
+		if off+4 > start {
+			return 0
+		}
+		return u32(off)
+	}
+
+	h.SN76489Clock = field(0x0C)
+	h.YM2413Clock = field(0x10)
+	h.TotalSamples = field(0x18)
+	h.LoopSamples = field(0x20)
+	h.Rate = field(0x24)
+	if h.Version >= 0x110 {
+		h.YM2612Clock = field(0x2C)
+		h.YM2151Clock = field(0x30)
+	}
+	if h.Version >= 0x151 {
+		h.YM3812Clock = field(0x50)
+		h.YM3526Clock = field(0x54)
+		h.Y8950Clock = field(0x58)
+	}
+
+	if gd3 := reloff(0x14); gd3 != 0 {
+		if gd3 >= len(buf) {
+			return nil, fmt.Errorf("%w: GD3 offset %#x past end of file", ErrFormat, gd3)
+		}
+		tag, err := parseTag(buf[gd3:])
+		if err != nil {
+			return nil, err
+		}
+		f.Tag = tag
+		if gd3 > start && gd3 < end {
+			end = gd3
+		}
+	}
+
+	f.Data = buf[start:end]
+
+	if loop := reloff(0x1C); loop != 0 {
+		if loop < start || loop >= end {
+			return nil, fmt.Errorf("%w: loop offset %#x out of data", ErrFormat, loop)
+		}
+		f.LoopOffset = loop - start
+	}
+
+	if err := f.scan(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// scan walks the command stream, checking it's well-formed and collecting
+// data blocks.
+func (f *File) scan() error {
+	for pos := 0; pos < len(f.Data); {
+		n, err := cmdLen(f.Data[pos:])
+		if err != nil {
+			return fmt.Errorf("%w at offset %#x", err, pos)
+		}
+		cmd := f.Data[pos]
+		if cmd == 0x66 {
+			break
+		}
+		if cmd == 0x67 {
+			f.Blocks = append(f.Blocks, DataBlock{
+				Type: f.Data[pos+2],
+				Data: f.Data[pos+7 : pos+n],
+			})
+		}
+		pos += n
+	}
+	return nil
+}
+
+// cmdLen returns the length of the command at the start of data, including
+// its operands. Commands of chips that aren't emulated, and the ones the VGM
+// specification reserves for future chips, are given their specified length
+// so that they can be skipped.
+func cmdLen(data []byte) (int, error) {
+	cmd := data[0]
+	n := 0
+	switch {
+	case cmd == 0x62, cmd == 0x63, cmd == 0x66, cmd >= 0x70 && cmd <= 0x8f:
+		n = 1
+	case cmd >= 0x30 && cmd <= 0x3f, // reserved, 1 operand
+		cmd == 0x4f, cmd == 0x50, cmd == 0x94:
+		n = 2
+	case cmd >= 0x40 && cmd <= 0x4e, // reserved, 2 operands
+		cmd >= 0xa1 && cmd <= 0xaf, // reserved, 2 operands
+		cmd >= 0x51 && cmd <= 0x5f, cmd == 0x61, cmd == 0xa0, cmd >= 0xb0 && cmd <= 0xbf:
+		n = 3
+	case cmd >= 0xc9 && cmd <= 0xcf, // reserved, 3 operands
+		cmd >= 0xd7 && cmd <= 0xdf, // reserved, 3 operands
+		cmd >= 0xc0 && cmd <= 0xc8, cmd >= 0xd0 && cmd <= 0xd6:
+		n = 4
+	case cmd >= 0xe2, // reserved, 4 operands
+		cmd == 0xe0, cmd == 0xe1, cmd == 0x90, cmd == 0x91, cmd == 0x95:
+		n = 5
+	case cmd == 0x92:
+		n = 6
+	case cmd == 0x93:
+		n = 11
+	case cmd == 0x68:
+		n = 12
+	case cmd == 0x67:
+		if len(data) < 7 {
+			return 0, fmt.Errorf("%w: truncated data block", ErrFormat)
+		}
+		n = 7 + int(binary.LittleEndian.Uint32(data[3:])&0x7fffffff)
+	default:
+		return 0, fmt.Errorf("%w: unknown command %#02x", ErrFormat, cmd)
+	}
+	if n > len(data) {
+		return 0, fmt.Errorf("%w: truncated command %#02x", ErrFormat, cmd)
+	}
+	return n, nil
+}
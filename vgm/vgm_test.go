@@ -0,0 +1,264 @@
+package vgm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/arl/blip"
+	"github.com/arl/blip/opl2"
+)
+
+// toneCommands returns commands programming a 440 Hz sine on OPL2 channel 0.
+func toneCommands() []byte {
+	var cmds []byte
+	for _, w := range [][2]byte{
+		{0x20, 0x01}, {0x40, 0x3f}, {0x23, 0x01}, {0x43, 0x00},
+		{0x63, 0xf0}, {0x83, 0x00}, {0xa0, 0x41}, {0xb0, 0x32},
+	} {
+		cmds = append(cmds, 0x5a, w[0], w[1])
+	}
+	return cmds
+}
+
+func gd3(fields ...string) []byte {
+	var body []byte
+	for i := range 11 {
+		var s string
+		if i < len(fields) {
+			s = fields[i]
+		}
+		for _, c := range utf16.Encode([]rune(s)) {
+			body = binary.LittleEndian.AppendUint16(body, c)
+		}
+		body = append(body, 0, 0)
+	}
+	b := []byte("Gd3 ")
+	b = binary.LittleEndian.AppendUint32(b, 0x100)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)))
+	return append(b, body...)
+}
+
+// buildVGM builds a version 1.51 VGM file using a YM3812. loop is the offset
+// in cmds of the loop start, or -1.
+func buildVGM(cmds []byte, loop int, samples, loopSamples uint32, tag []byte) []byte {
+	const dataStart = 0x80
+
+	b := make([]byte, dataStart)
+	copy(b, "Vgm ")
+	put := func(off int, v uint32) { binary.LittleEndian.PutUint32(b[off:], v) }
+
+	put(0x08, 0x151)
+	put(0x18, samples)
+	put(0x34, dataStart-0x34)
+	put(0x50, opl2.ClockRate)
+	if loop >= 0 {
+		put(0x1c, uint32(dataStart+loop-0x1c))
+		put(0x20, loopSamples)
+	}
+	b = append(b, cmds...)
+	if tag != nil {
+		put(0x14, uint32(len(b)-0x14))
+		b = append(b, tag...)
+	}
+	put(0x04, uint32(len(b)-0x04))
+	return b
+}
+
+func TestParse(t *testing.T) {
+	cmds := append(toneCommands(),
+		0x67, 0x66, 0x00, 3, 0, 0, 0, 1, 2, 3, // data block
+		0x62, // wait 1/60 s
+		0x66, // end
+	)
+	raw := buildVGM(cmds, -1, 735, 0, gd3("Track", "", "Game", "", "System", "", "Author"))
+
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(raw)
+	zw.Close()
+
+	for name, data := range map[string][]byte{"vgm": raw, "vgz": gz.Bytes()} {
+		t.Run(name, func(t *testing.T) {
+			f, err := Parse(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if f.Header.Version != 0x151 {
+				t.Errorf("version = %#x, want 0x151", f.Header.Version)
+			}
+			if f.Header.YM3812Clock != opl2.ClockRate {
+				t.Errorf("YM3812 clock = %d, want %d", f.Header.YM3812Clock, opl2.ClockRate)
+			}
+			if f.LoopOffset != -1 {
+				t.Errorf("loop offset = %d, want -1", f.LoopOffset)
+			}
+			if !bytes.Equal(f.Data, cmds) {
+				t.Errorf("data = % x, want % x", f.Data, cmds)
+			}
+			if len(f.Blocks) != 1 || !bytes.Equal(f.Blocks[0].Data, []byte{1, 2, 3}) {
+				t.Errorf("blocks = %v, want a single block of 3 bytes", f.Blocks)
+			}
+			if f.Tag == nil || f.Tag.Track != "Track" || f.Tag.Game != "Game" || f.Tag.Author != "Author" {
+				t.Errorf("tag = %+v", f.Tag)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := map[string][]byte{
+		"not vgm":         []byte("RIFF0000WAVE0000000000000000000000000000000000000000000000000000"),
+		"unknown command": buildVGM([]byte{0x62, 0x20, 0x66}, -1, 735, 0, nil),
+		"truncated":       buildVGM([]byte{0x62, 0x5a, 0x20}, -1, 735, 0, nil),
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := Parse(bytes.NewReader(data)); !errors.Is(err, ErrFormat) {
+				t.Errorf("err = %v, want ErrFormat", err)
+			}
+		})
+	}
+}
+
+func TestPlay(t *testing.T) {
+	const sampleRate = 44100
+
+	// 1/4 s of tone followed by a looping 1/60 s wait.
+	cmds := append(toneCommands(), 0x61, 0x44, 0x2b) // wait 11076 samples
+	loop := len(cmds)
+	cmds = append(cmds, 0x62, 0x66)
+
+	f, err := Parse(bytes.NewReader(buildVGM(cmds, loop, 11076+735, 735, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bl := blip.NewBuffer(sampleRate / 10)
+	p, err := NewPlayer(f, bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl.SetRates(p.ClockRate(), sampleRate)
+	p.SetLoops(2)
+
+	if p.Length() != 11076+3*735 {
+		t.Fatalf("length = %d, want %d", p.Length(), 11076+3*735)
+	}
+
+	total := 0
+	var out []int16
+	buf := make([]int16, 1024)
+	for {
+		n, err := p.Play(441)
+		total += n
+		for bl.SamplesAvailable() > 0 {
+			m := bl.ReadSamples(buf, len(buf), blip.Mono)
+			out = append(out, buf[:m]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if total != p.Length() {
+		t.Errorf("played %d samples, want %d", total, p.Length())
+	}
+	if d := len(out) - total; d < -1 || d > 1 {
+		t.Errorf("rendered %d samples, want %d", len(out), total)
+	}
+
+	peak := int16(0)
+	for _, s := range out {
+		peak = max(peak, s)
+	}
+	if peak < 3000 {
+		t.Errorf("peak = %d, want a full volume tone", peak)
+	}
+}
+
+func TestPlayDACWaits(t *testing.T) {
+	// YM2612 DAC writes waiting 15 samples each.
+	cmds := bytes.Repeat([]byte{0x8f}, 10)
+	cmds = append(cmds, 0x66)
+	f, err := Parse(bytes.NewReader(buildVGM(cmds, -1, 150, 0, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bl := blip.NewBuffer(1000)
+	p, err := NewPlayer(f, bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl.SetRates(p.ClockRate(), SampleRate)
+
+	total := 0
+	for {
+		n, err := p.Play(100)
+		total += n
+		bl.Clear()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if total != 150 {
+		t.Errorf("played %d samples, want 150", total)
+	}
+}
+
+func TestNoChip(t *testing.T) {
+	raw := buildVGM([]byte{0x62, 0x66}, -1, 735, 0, nil)
+	binary.LittleEndian.PutUint32(raw[0x50:], 0)
+
+	f, err := Parse(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewPlayer(f, blip.NewBuffer(100)); err != ErrNoChip {
+		t.Errorf("err = %v, want ErrNoChip", err)
+	}
+}
+
+func TestSkipReserved(t *testing.T) {
+	// Commands reserved for future chips, with their specified lengths.
+	cmds := []byte{
+		0x30, 1,
+		0x4e, 1, 2,
+		0xa1, 1, 2,
+		0xc9, 1, 2, 3,
+		0xdf, 1, 2, 3,
+		0xff, 1, 2, 3, 4,
+		0x62, 0x66,
+	}
+	f, err := Parse(bytes.NewReader(buildVGM(cmds, -1, 735, 0, nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bl := blip.NewBuffer(1000)
+	p, err := NewPlayer(f, bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bl.SetRates(p.ClockRate(), SampleRate)
+
+	n, err := p.Play(1000)
+	if err != io.EOF {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+	if n != 735 {
+		t.Errorf("played %d samples, want 735", n)
+	}
+}
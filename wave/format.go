@@ -0,0 +1,106 @@
+package wave
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// SampleFormat is the encoding of samples in a wave file.
+type SampleFormat uint8
+
+const (
+	PCM16   SampleFormat = iota // 16-bit signed integer, the default
+	PCM8                        // 8-bit unsigned integer
+	PCM24                       // 24-bit signed integer, packed in 3 bytes
+	PCM32                       // 32-bit signed integer
+	Float32                     // 32-bit IEEE float, in the [-1, 1] range
+)
+
+// Size returns the size in bytes of a single sample.
+func (f SampleFormat) Size() int {
+	switch f {
+	case PCM8:
+		return 1
+	case PCM24:
+		return 3
+	case PCM32, Float32:
+		return 4
+	}
+	return 2
+}
+
+func (f SampleFormat) tag() uint16 {
+	if f == Float32 {
+		return formatFloat
+	}
+	return formatPCM
+}
+
+// putInt encodes v, a full scale 32-bit signed sample, at the start of b.
+func (f SampleFormat) putInt(b []byte, v int32) {
+	switch f {
+	case PCM8:
+		b[0] = byte(v>>24) ^ 0x80
+	case PCM16:
+		binary.LittleEndian.PutUint16(b, uint16(v>>16))
+	case PCM24:
+		b[0], b[1], b[2] = byte(v>>8), byte(v>>16), byte(v>>24)
+	case PCM32:
+		binary.LittleEndian.PutUint32(b, uint32(v))
+	case Float32:
+		binary.LittleEndian.PutUint32(b, math.Float32bits(float32(float64(v)/(1<<31))))
+	}
+}
+
+// putFloat encodes v, a sample in the [-1, 1] range, at the start of b.
+// Integer formats clamp values out of range.
+func (f SampleFormat) putFloat(b []byte, v float32) {
+	if f == Float32 {
+		binary.LittleEndian.PutUint32(b, math.Float32bits(v))
+		return
+	}
+	s := min(max(math.Round(float64(v)*(1<<31)), math.MinInt32), math.MaxInt32)
+	f.putInt(b, int32(s))
+}
+
+// Speaker positions of the channel mask of WAVE_FORMAT_EXTENSIBLE files.
+const (
+	SpeakerFrontLeft          = 0x1
+	SpeakerFrontRight         = 0x2
+	SpeakerFrontCenter        = 0x4
+	SpeakerLowFrequency       = 0x8
+	SpeakerBackLeft           = 0x10
+	SpeakerBackRight          = 0x20
+	SpeakerFrontLeftOfCenter  = 0x40
+	SpeakerFrontRightOfCenter = 0x80
+	SpeakerBackCenter         = 0x100
+	SpeakerSideLeft           = 0x200
+	SpeakerSideRight          = 0x400
+)
+
+// defaultMask returns the conventional channel mask for the given number of
+// channels, or 0 (no assignment) if there's none.
+func defaultMask(channels int) uint32 {
+	switch channels {
+	case 1:
+		return SpeakerFrontCenter
+	case 2:
+		return SpeakerFrontLeft | SpeakerFrontRight
+	case 3:
+		return SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter
+	case 4: // quad
+		return SpeakerFrontLeft | SpeakerFrontRight | SpeakerBackLeft | SpeakerBackRight
+	case 5: // 5.0
+		return SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerBackLeft | SpeakerBackRight
+	case 6: // 5.1
+		return SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+			SpeakerBackLeft | SpeakerBackRight
+	case 7: // 6.1
+		return SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+			SpeakerBackCenter | SpeakerSideLeft | SpeakerSideRight
+	case 8: // 7.1
+		return SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter | SpeakerLowFrequency |
+			SpeakerBackLeft | SpeakerBackRight | SpeakerSideLeft | SpeakerSideRight
+	}
+	return 0
+}
@@ -0,0 +1,256 @@
+package wave
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Info holds the textual tags of a LIST/INFO chunk.
+type Info struct {
+	Title     string // INAM
+	Artist    string // IART
+	Album     string // IPRD
+	Comment   string // ICMT
+	Date      string // ICRD
+	Genre     string // IGNR
+	Software  string // ISFT
+	Copyright string // ICOP
+}
+
+func (info *Info) fields() []struct {
+	id  string
+	val *string
+} {
+	return []struct {
+		id  string
+		val *string
+	}{
+		{"INAM", &info.Title},
+		{"IART", &info.Artist},
+		{"IPRD", &info.Album},
+		{"ICMT", &info.Comment},
+		{"ICRD", &info.Date},
+		{"IGNR", &info.Genre},
+		{"ISFT", &info.Software},
+		{"ICOP", &info.Copyright},
+	}
+}
+
+// A Cue marks a position in the sample data.
+type Cue struct {
+	ID       uint32
+	Position int // in sample frames
+	Label    string
+}
+
+// LoopType is the playback direction of a sampler loop.
+type LoopType uint32
+
+const (
+	LoopForward  LoopType = 0
+	LoopPingPong LoopType = 1
+	LoopBackward LoopType = 2
+)
+
+// A Loop is a sampler loop stored in the smpl chunk.
+type Loop struct {
+	Type      LoopType
+	Start     int // first sample frame of the loop
+	End       int // last sample frame of the loop, inclusive
+	PlayCount int // 0 for infinite
+}
+
+// metadata holds the metadata chunks of a wave file.
+type metadata struct {
+	info  Info
+	cues  []Cue
+	loops []Loop
+}
+
+// appendChunk appends a chunk with the given id and body to b, padding it to
+// an even size.
+func appendChunk(b []byte, id string, body []byte) []byte {
+	b = append(b, id...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(body)))
+	b = append(b, body...)
+	if len(body)&1 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// appendZString appends s as a null-terminated string.
+func appendZString(b []byte, s string) []byte {
+	return append(append(b, s...), 0)
+}
+
+// encode returns the metadata chunks, ready to be appended to the file.
+// sampleRate is used for the sample period of the smpl chunk.
+func (m *metadata) encode(sampleRate int) []byte {
+	le := binary.LittleEndian
+	var b []byte
+
+	var info []byte
+	for _, f := range m.info.fields() {
+		if *f.val != "" {
+			info = appendChunk(info, f.id, appendZString(nil, *f.val))
+		}
+	}
+	if info != nil {
+		b = appendChunk(b, "LIST", append([]byte("INFO"), info...))
+	}
+
+	if len(m.cues) > 0 {
+		cue := le.AppendUint32(nil, uint32(len(m.cues)))
+		adtl := []byte("adtl")
+		for _, c := range m.cues {
+			cue = le.AppendUint32(cue, c.ID)
+			cue = le.AppendUint32(cue, uint32(c.Position)) // play order position
+			cue = append(cue, "data"...)
+			cue = le.AppendUint32(cue, 0) // chunk start
+			cue = le.AppendUint32(cue, 0) // block start
+			cue = le.AppendUint32(cue, uint32(c.Position))
+			if c.Label != "" {
+				adtl = appendChunk(adtl, "labl", appendZString(le.AppendUint32(nil, c.ID), c.Label))
+			}
+		}
+		b = appendChunk(b, "cue ", cue)
+		if len(adtl) > 4 {
+			b = appendChunk(b, "LIST", adtl)
+		}
+	}
+
+	if len(m.loops) > 0 {
+		smpl := make([]byte, 0, 36+24*len(m.loops))
+		smpl = le.AppendUint32(smpl, 0)                      // manufacturer
+		smpl = le.AppendUint32(smpl, 0)                      // product
+		smpl = le.AppendUint32(smpl, uint32(1e9/sampleRate)) // sample period, in ns
+		smpl = le.AppendUint32(smpl, 60)                     // MIDI unity note
+		smpl = le.AppendUint32(smpl, 0)                      // MIDI pitch fraction
+		smpl = le.AppendUint32(smpl, 0)                      // SMPTE format
+		smpl = le.AppendUint32(smpl, 0)                      // SMPTE offset
+		smpl = le.AppendUint32(smpl, uint32(len(m.loops)))
+		smpl = le.AppendUint32(smpl, 0) // sampler data size
+		for i, l := range m.loops {
+			smpl = le.AppendUint32(smpl, uint32(i)) // cue point ID
+			smpl = le.AppendUint32(smpl, uint32(l.Type))
+			smpl = le.AppendUint32(smpl, uint32(l.Start))
+			smpl = le.AppendUint32(smpl, uint32(l.End))
+			smpl = le.AppendUint32(smpl, 0) // fraction
+			smpl = le.AppendUint32(smpl, uint32(l.PlayCount))
+		}
+		b = appendChunk(b, "smpl", smpl)
+	}
+	return b
+}
+
+// decode parses a metadata chunk, ignoring chunks that aren't metadata.
+func (m *metadata) decode(id string, body []byte) error {
+	le := binary.LittleEndian
+
+	switch id {
+	case "LIST":
+		if len(body) < 4 {
+			return fmt.Errorf("%w: LIST chunk too short", ErrFormat)
+		}
+		return subchunks(body[4:], func(sid string, sub []byte) error {
+			switch string(body[:4]) {
+			case "INFO":
+				for _, f := range m.info.fields() {
+					if f.id == sid {
+						*f.val = zstring(sub)
+					}
+				}
+			case "adtl":
+				if sid == "labl" && len(sub) >= 4 {
+					m.setLabel(le.Uint32(sub), zstring(sub[4:]))
+				}
+			}
+			return nil
+		})
+
+	case "cue ":
+		if len(body) < 4 || int64(len(body)) < 4+24*int64(le.Uint32(body)) {
+			return fmt.Errorf("%w: truncated cue chunk", ErrFormat)
+		}
+		for i := range int(le.Uint32(body)) {
+			p := body[4+24*i:]
+			cue := Cue{ID: le.Uint32(p), Position: int(le.Uint32(p[20:]))}
+			// Labels may come before the cue chunk.
+			for _, c := range m.cues {
+				if c.ID == cue.ID {
+					cue.Label = c.Label
+				}
+			}
+			m.removeCue(cue.ID)
+			m.cues = append(m.cues, cue)
+		}
+
+	case "smpl":
+		if len(body) < 36 || int64(len(body)) < 36+24*int64(le.Uint32(body[28:])) {
+			return fmt.Errorf("%w: truncated smpl chunk", ErrFormat)
+		}
+		for i := range int(le.Uint32(body[28:])) {
+			p := body[36+24*i:]
+			m.loops = append(m.loops, Loop{
+				Type:      LoopType(le.Uint32(p[4:])),
+				Start:     int(le.Uint32(p[8:])),
+				End:       int(le.Uint32(p[12:])),
+				PlayCount: int(le.Uint32(p[20:])),
+			})
+		}
+	}
+	return nil
+}
+
+// setLabel sets the label of the cue with the given ID, creating the cue if
+// it doesn't exist yet.
+func (m *metadata) setLabel(id uint32, label string) {
+	for i := range m.cues {
+		if m.cues[i].ID == id {
+			m.cues[i].Label = label
+			return
+		}
+	}
+	m.cues = append(m.cues, Cue{ID: id, Label: label})
+}
+
+func (m *metadata) removeCue(id uint32) {
+	for i := range m.cues {
+		if m.cues[i].ID == id {
+			m.cues = append(m.cues[:i], m.cues[i+1:]...)
+			return
+		}
+	}
+}
+
+// subchunks calls f for each chunk contained in b.
+func subchunks(b []byte, f func(id string, body []byte) error) error {
+	for len(b) >= 8 {
+		id := string(b[:4])
+		size := binary.LittleEndian.Uint32(b[4:])
+		b = b[8:]
+		if int64(size) > int64(len(b)) {
+			return fmt.Errorf("%w: truncated %q chunk", ErrFormat, id)
+		}
+		if err := f(id, b[:size]); err != nil {
+			return err
+		}
+		b = b[min(len(b), int(size)+int(size&1)):]
+	}
+	return nil
+}
+
+// zstring returns b up to its first null byte.
+func zstring(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// isMetadata reports whether a chunk with the given id holds metadata.
+func isMetadata(id string) bool {
+	return id == "LIST" || id == "cue " || id == "smpl"
+}
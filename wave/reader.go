@@ -0,0 +1,427 @@
+package wave
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/arl/blip/internal/pcm"
+)
+
+// Format tags of the fmt chunk.
+const (
+	formatPCM        = 1
+	formatFloat      = 3
+	formatExtensible = 0xFFFE
+)
+
+var (
+	// ErrFormat is returned when reading data that isn't a valid wave file.
+	ErrFormat = errors.New("wave: invalid format")
+
+	// ErrUnsupported is returned when reading a valid wave file whose sample
+	// format isn't supported.
+	ErrUnsupported = errors.New("wave: unsupported sample format")
+)
+
+// A Reader reads samples from a wave file. RF64 files are supported.
+//
+// PCM files with 8, 16, 24 or 32 bits per sample and IEEE float files with 32
+// bits per sample are supported. Samples can be read in any of those formats
+// as int16 or float32, they're converted on the fly. Multichannel samples are
+// interleaved.
+type Reader struct {
+	r io.Reader
+	c io.Closer // nil if the source isn't owned
+
+	channels   int
+	sampleRate int
+	bits       int
+	float      bool
+	chanMask   uint32
+
+	data pcm.Data // sample data, Remain being -1 if its size is unknown
+
+	meta     metadata
+	off      int64 // offset of sample data from the start of the file
+	pad      bool  // whether sample data is followed by a pad byte
+	trailing bool  // whether chunks following sample data have been read
+}
+
+// NewReader creates a Reader reading a wave file from r. It parses the file
+// header up to the start of sample data.
+//
+// Metadata chunks located after sample data are read right away if r is an
+// io.ReadSeeker, otherwise they're only read once all samples have been read.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := bufio.NewReader(r)
+	rd := &Reader{r: br, data: pcm.Data{R: br}}
+
+	rs, seekable := r.(io.ReadSeeker)
+	var start int64
+	if seekable {
+		var err error
+		if start, err = rs.Seek(0, io.SeekCurrent); err != nil {
+			seekable = false
+		}
+	}
+
+	if err := rd.readHeader(); err != nil {
+		return nil, err
+	}
+
+	if seekable && rd.data.Remain >= 0 {
+		// Read trailing chunks, then come back to sample data.
+		data := start + rd.off
+		if _, err := rs.Seek(data+rd.data.Remain, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(rs)
+		if err := rd.readTrailer(); err != nil {
+			return nil, err
+		}
+		if _, err := rs.Seek(data, io.SeekStart); err != nil {
+			return nil, err
+		}
+		br.Reset(rs)
+	}
+	return rd, nil
+}
+
+// Open opens the wave file at path for reading. Close must be called when done
+// reading samples.
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.c = f
+	return r, nil
+}
+
+// Close closes the file opened by Open. It does nothing for a Reader created
+// with NewReader.
+func (r *Reader) Close() error {
+	if r.c == nil {
+		return nil
+	}
+	return r.c.Close()
+}
+
+// Channels returns the number of channels.
+func (r *Reader) Channels() int { return r.channels }
+
+// ChannelMask returns the speakers the channels map to, as a combination of
+// the Speaker constants. It's only set by WAVE_FORMAT_EXTENSIBLE files.
+func (r *Reader) ChannelMask() uint32 { return r.chanMask }
+
+// SampleRate returns the number of sample frames per second.
+func (r *Reader) SampleRate() int { return r.sampleRate }
+
+// BitsPerSample returns the size of a single sample in the file, in bits.
+func (r *Reader) BitsPerSample() int { return r.bits }
+
+// Float reports whether samples are stored as IEEE floats.
+func (r *Reader) Float() bool { return r.float }
+
+// Info returns the tags of the LIST/INFO chunk.
+func (r *Reader) Info() Info { return r.meta.info }
+
+// Cues returns the cue markers of the file.
+func (r *Reader) Cues() []Cue { return r.meta.cues }
+
+// Loops returns the sampler loops of the smpl chunk.
+func (r *Reader) Loops() []Loop { return r.meta.loops }
+
+// SampleCount returns the number of samples (of all channels) that remain to
+// be read, or -1 if the file doesn't specify the size of its sample data.
+func (r *Reader) SampleCount() int {
+	if r.data.Remain < 0 {
+		return -1
+	}
+	return int(r.data.Remain / int64(r.bits/8))
+}
+
+type chunkHeader struct {
+	id   [4]byte
+	size uint32
+}
+
+func (r *Reader) readChunkHeader() (chunkHeader, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r.r, b[:]); err != nil {
+		return chunkHeader{}, err
+	}
+	var ch chunkHeader
+	copy(ch.id[:], b[:4])
+	ch.size = binary.LittleEndian.Uint32(b[4:])
+	return ch, nil
+}
+
+func (r *Reader) readHeader() error {
+	var riff [12]byte
+	if _, err := io.ReadFull(r.r, riff[:]); err != nil {
+		return ErrFormat
+	}
+	if string(riff[8:12]) != "WAVE" {
+		return ErrFormat
+	}
+	rf64 := false
+	switch string(riff[0:4]) {
+	case "RIFF":
+	case "RF64", "BW64":
+		rf64 = true
+	default:
+		return ErrFormat
+	}
+
+	gotFmt := false
+	dataSize := int64(-1) // from ds64 chunk
+	r.off = 12
+	for {
+		ch, err := r.readChunkHeader()
+		if err != nil {
+			return fmt.Errorf("%w: missing data chunk", ErrFormat)
+		}
+		r.off += 8
+
+		switch id := string(ch.id[:]); id {
+		case "ds64":
+			if !rf64 || ch.size < ds64Size {
+				return fmt.Errorf("%w: invalid ds64 chunk", ErrFormat)
+			}
+			// The table of chunk sizes following the sizes of the file and
+			// data chunks isn't needed.
+			var b [ds64Size]byte
+			if _, err := io.ReadFull(r.r, b[:]); err != nil {
+				return fmt.Errorf("%w: truncated ds64 chunk", ErrFormat)
+			}
+			if err := pcm.SkipChunk(r.r, ch.size-ds64Size); err != nil {
+				return fmt.Errorf("%w: truncated ds64 chunk", ErrFormat)
+			}
+			dataSize = int64(binary.LittleEndian.Uint64(b[8:]))
+			if dataSize < 0 {
+				return fmt.Errorf("%w: invalid ds64 data size", ErrFormat)
+			}
+		case "fmt ":
+			if err := r.readFmt(ch.size); err != nil {
+				return err
+			}
+			gotFmt = true
+		case "data":
+			if !gotFmt {
+				return fmt.Errorf("%w: data chunk before fmt chunk", ErrFormat)
+			}
+			r.data.Remain = int64(ch.size)
+			if ch.size == math.MaxUint32 {
+				// Either the real size is in the ds64 chunk, or it's a
+				// streamed file that must be read until EOF.
+				r.data.Remain = dataSize
+			}
+			r.pad = r.data.Remain > 0 && r.data.Remain&1 != 0
+			return nil
+		default:
+			if err := r.readChunk(id, ch.size); err != nil {
+				return err
+			}
+		}
+		r.off += int64(ch.size) + int64(ch.size&1)
+	}
+}
+
+// maxMetadataSize is the maximum size of a metadata chunk.
+const maxMetadataSize = 1 << 20
+
+// readChunk reads a chunk body, parsing it if it holds metadata, skipping it
+// otherwise.
+func (r *Reader) readChunk(id string, size uint32) error {
+	if !isMetadata(id) {
+		if err := pcm.SkipChunk(r.r, size); err != nil {
+			return fmt.Errorf("%w: truncated %q chunk", ErrFormat, id)
+		}
+		return nil
+	}
+
+	b, err := pcm.ReadChunk(r.r, size, maxMetadataSize)
+	if err == pcm.ErrTooLarge {
+		return fmt.Errorf("%w: %q chunk too large", ErrFormat, id)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: truncated %q chunk", ErrFormat, id)
+	}
+	return r.meta.decode(id, b)
+}
+
+// readTrailer reads the chunks following sample data, if not already done.
+// The data pad byte must be the next byte to read.
+func (r *Reader) readTrailer() error {
+	if r.trailing {
+		return nil
+	}
+	r.trailing = true
+
+	if r.pad {
+		if _, err := io.ReadFull(r.r, make([]byte, 1)); err != nil {
+			return nil
+		}
+	}
+	for {
+		ch, err := r.readChunkHeader()
+		if err != nil {
+			// No more chunks.
+			return nil
+		}
+		if err := r.readChunk(string(ch.id[:]), ch.size); err != nil {
+			return err
+		}
+	}
+}
+
+// subformat GUID suffix shared by all WAVE_FORMAT_EXTENSIBLE subformats, the
+// first 2 bytes holding the format tag.
+var guidSuffix = [14]byte{
+	0x00, 0x00, 0x00, 0x00, 0x10, 0x00, 0x80, 0x00,
+	0x00, 0xAA, 0x00, 0x38, 0x9B, 0x71,
+}
+
+// maxFmtSize is the maximum size of the fmt chunk. Supported formats need at
+// most 40 bytes.
+const maxFmtSize = 1024
+
+func (r *Reader) readFmt(size uint32) error {
+	if size < 16 {
+		return fmt.Errorf("%w: fmt chunk too short", ErrFormat)
+	}
+	b, err := pcm.ReadChunk(r.r, size, maxFmtSize)
+	if err == pcm.ErrTooLarge {
+		return fmt.Errorf("%w: fmt chunk too large", ErrFormat)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: truncated fmt chunk", ErrFormat)
+	}
+
+	tag := binary.LittleEndian.Uint16(b[0:])
+	r.channels = int(binary.LittleEndian.Uint16(b[2:]))
+	r.sampleRate = int(binary.LittleEndian.Uint32(b[4:]))
+	blockAlign := int(binary.LittleEndian.Uint16(b[12:]))
+	r.bits = int(binary.LittleEndian.Uint16(b[14:]))
+
+	if tag == formatExtensible {
+		if size < 40 || binary.LittleEndian.Uint16(b[16:]) < 22 {
+			return fmt.Errorf("%w: extensible fmt chunk too short", ErrFormat)
+		}
+		if [14]byte(b[26:40]) != guidSuffix {
+			return ErrUnsupported
+		}
+		r.chanMask = binary.LittleEndian.Uint32(b[20:])
+		tag = binary.LittleEndian.Uint16(b[24:])
+	}
+
+	if r.channels == 0 || r.sampleRate == 0 {
+		return fmt.Errorf("%w: no channels or zero sample rate", ErrFormat)
+	}
+
+	switch {
+	case tag == formatPCM && (r.bits == 8 || r.bits == 16 || r.bits == 24 || r.bits == 32):
+	case tag == formatFloat && r.bits == 32:
+		r.float = true
+	default:
+		return ErrUnsupported
+	}
+
+	if blockAlign != r.channels*r.bits/8 {
+		return fmt.Errorf("%w: block align %d doesn't match format", ErrFormat, blockAlign)
+	}
+	return nil
+}
+
+// read reads the raw bytes of at most n samples.
+func (r *Reader) read(n int) ([]byte, error) {
+	b, err := r.data.Read(n, r.bits/8)
+	if err == io.EOF && r.data.Remain == 0 {
+		if err := r.readTrailer(); err != nil {
+			return nil, err
+		}
+	}
+	return b, err
+}
+
+// sample decodes the sample at the start of b as a signed integer using all 32
+// bits, or as a float.
+func (r *Reader) sample(b []byte) (int32, float32) {
+	switch r.bits {
+	case 8:
+		return int32(b[0]^0x80) << 24, 0
+	case 16:
+		return int32(binary.LittleEndian.Uint16(b)) << 16, 0
+	case 24:
+		return int32(b[0])<<8 | int32(b[1])<<16 | int32(b[2])<<24, 0
+	}
+	v := binary.LittleEndian.Uint32(b)
+	if r.float {
+		return 0, math.Float32frombits(v)
+	}
+	return int32(v), 0
+}
+
+// ReadInt16 reads at most len(p) samples into p, converting them to 16-bit
+// signed integers. It returns the number of samples read and io.EOF at the end
+// of sample data.
+func (r *Reader) ReadInt16(p []int16) (int, error) {
+	b, err := r.read(len(p))
+	if err != nil {
+		return 0, err
+	}
+	size := r.bits / 8
+	n := len(b) / size
+	for i := range n {
+		iv, fv := r.sample(b[i*size:])
+		if r.float {
+			p[i] = int16(min(max(math.Round(float64(fv)*32768), math.MinInt16), math.MaxInt16))
+			continue
+		}
+		p[i] = int16(iv >> 16)
+	}
+	return n, nil
+}
+
+// ReadFloat32 reads at most len(p) samples into p, converting them to floats
+// in the [-1, 1] range. It returns the number of samples read and io.EOF at
+// the end of sample data.
+func (r *Reader) ReadFloat32(p []float32) (int, error) {
+	b, err := r.read(len(p))
+	if err != nil {
+		return 0, err
+	}
+	size := r.bits / 8
+	n := len(b) / size
+	for i := range n {
+		iv, fv := r.sample(b[i*size:])
+		if !r.float {
+			fv = float32(float64(iv) / (1 << 31))
+		}
+		p[i] = fv
+	}
+	return n, nil
+}
+
+// ReadAllInt16 reads all remaining samples, converted to 16-bit signed
+// integers.
+func (r *Reader) ReadAllInt16() ([]int16, error) {
+	return pcm.ReadAll(r.ReadInt16, r.SampleCount())
+}
+
+// ReadAllFloat32 reads all remaining samples, converted to floats in the
+// [-1, 1] range.
+func (r *Reader) ReadAllFloat32() ([]float32, error) {
+	return pcm.ReadAll(r.ReadFloat32, r.SampleCount())
+}
@@ -1,130 +1,302 @@
 package wave
 
 import (
-	"bytes"
 	"encoding/binary"
 	"io"
-	"os"
+	"math"
+
+	"github.com/arl/blip/internal/patch"
 )
 
 // A Writer writes samples to a wave file.
+//
+// Samples are written as 16-bit PCM by default. Other sample formats and
+// channel counts can be selected with SetFormat and SetChannels, before the
+// first sample is written. Files with more than 2 channels, more than 16 bits
+// per sample, float samples or an explicit channel mask are written with the
+// WAVE_FORMAT_EXTENSIBLE format.
+//
+// Tags, cue markers and sampler loops can be added with SetInfo, AddCue and
+// AddLoop. They're written after sample data when the Writer is closed.
+//
+// Files whose size exceed the 4 GiB limit of RIFF are automatically promoted
+// to RF64.
+//
+// Sample data is streamed to the destination as it's written. The header
+// holds the size of the sample data though, which is only known when the
+// Writer is closed. If the destination is an io.WriteSeeker, such as the
+// *os.File created by NewFile, the header is patched on Close. Otherwise, the
+// sample data is buffered in memory until Close, where the header can be
+// written first.
 type Writer struct {
-	w           io.WriteCloser
+	out         *patch.Writer
 	sampleRate  int
 	sampleCount int
-	chanCount   uint8
-	bb          bytes.Buffer
-}
+	chanCount   int
+	format      SampleFormat
+	chanMask    uint32
+	customMask  bool // whether chanMask was set by the user
+	meta        metadata
+	trailerSize int // size of metadata chunks following sample data
 
-type nopCloser struct{ io.Writer }
-
-func (nopCloser) Close() error { return nil }
+	buf [4096]byte
+}
 
-func newWriter(w io.WriteCloser, newSampleRate int) *Writer {
+func newWriter(out *patch.Writer, newSampleRate int) *Writer {
 	ww := &Writer{
-		w:          w,
+		out:        out,
 		sampleRate: newSampleRate,
 		chanCount:  1,
+		chanMask:   defaultMask(1),
 	}
 	return ww
 }
 
 // NewWriter creates a new Writer with the given sample rate, onto which samples
 // can be written with Write. Close must be called when done writing samples to
-// finalize the wave file.
+// finalize the wave file. Close doesn't close w.
 func NewWriter(w io.Writer, newSampleRate int) *Writer {
-	ww := &Writer{
-		w:          nopCloser{Writer: w},
-		sampleRate: newSampleRate,
-		chanCount:  1,
-	}
-	return ww
+	return newWriter(patch.New(w), newSampleRate)
 }
 
 // NewFile creates a new wave file at the given path with the given sample rate.
 // Close must be called when done writing samples to finalize the wave file.
 func NewFile(path string, newSampleRate int) (*Writer, error) {
-	f, err := os.Create(path)
+	out, err := patch.NewFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return newWriter(f, newSampleRate), nil
-}
-
-const sampleSize = 2
-
-func (w *Writer) header() [0x2C]byte {
-	dataSize := sampleSize * w.sampleCount
-	frameSize := sampleSize * w.chanCount
-	h := [0x2C]byte{
-		'R', 'I', 'F', 'F',
-		0, 0, 0, 0, //        length of rest of file
-		'W', 'A', 'V', 'E',
-		'f', 'm', 't', ' ',
-		16, 0, 0, 0, //       size of fmt chunk
-		1, 0, //              uncompressed format
-		0, 0, //              channel count
-		0, 0, 0, 0, //        sample rate
-		0, 0, 0, 0, //        bytes per second
-		0, 0, //              bytes per sample frame
-		sampleSize * 8, 0, // bits per sample
-		'd', 'a', 't', 'a',
-		0, 0, 0, 0, //        size of sample data
-		// ...                sample data
-	}
-
-	binary.LittleEndian.PutUint32(h[0x04:], uint32(len(h)-8+dataSize))
-
-	h[0x16] = w.chanCount
-	binary.LittleEndian.PutUint32(h[0x18:], uint32(w.sampleRate))
-	binary.LittleEndian.PutUint32(h[0x1C:], uint32(w.sampleRate)*uint32(frameSize))
-	h[0x20] = frameSize
-	binary.LittleEndian.PutUint32(h[0x28:], uint32(dataSize))
+	return newWriter(out, newSampleRate), nil
+}
+
+// extensible reports whether the header uses the WAVE_FORMAT_EXTENSIBLE
+// format.
+func (w *Writer) extensible() bool {
+	return w.chanCount > 2 || w.format.Size() > 2 || w.format == Float32 || w.customMask
+}
+
+func (w *Writer) dataSize() int64 {
+	return int64(w.sampleCount) * int64(w.format.Size())
+}
+
+// maxRIFFSize is the maximum size of the RIFF chunk, past which the file is
+// promoted to RF64.
+var maxRIFFSize int64 = math.MaxUint32
+
+// ds64Size is the size of the ds64 chunk body, without table entries.
+const ds64Size = 28
+
+func (w *Writer) header() []byte {
+	le := binary.LittleEndian
+	size := w.format.Size()
+	frameSize := size * w.chanCount
+	frames := uint64(w.sampleCount / w.chanCount)
+	dataSize := w.dataSize()
+
+	h := make([]byte, 0, 128)
+	h = append(h, "RIFF"...)
+	h = le.AppendUint32(h, 0) // length of rest of file
+	h = append(h, "WAVE"...)
+
+	// Reserve room for a ds64 chunk, in case the file gets too big for RIFF
+	// and has to be promoted to RF64.
+	h = append(h, "JUNK"...)
+	h = le.AppendUint32(h, ds64Size)
+	h = append(h, make([]byte, ds64Size)...)
+
+	h = append(h, "fmt "...)
+	if w.extensible() {
+		h = le.AppendUint32(h, 40)
+		h = le.AppendUint16(h, formatExtensible)
+	} else {
+		h = le.AppendUint32(h, 16)
+		h = le.AppendUint16(h, w.format.tag())
+	}
+	h = le.AppendUint16(h, uint16(w.chanCount))
+	h = le.AppendUint32(h, uint32(w.sampleRate))
+	h = le.AppendUint32(h, uint32(w.sampleRate*frameSize)) // bytes per second
+	h = le.AppendUint16(h, uint16(frameSize))              // bytes per sample frame
+	h = le.AppendUint16(h, uint16(size*8))                 // bits per sample
+	if w.extensible() {
+		h = le.AppendUint16(h, 22)             // size of extension
+		h = le.AppendUint16(h, uint16(size*8)) // valid bits per sample
+		h = le.AppendUint32(h, w.chanMask)
+		h = le.AppendUint16(h, w.format.tag()) // subformat GUID
+		h = append(h, guidSuffix[:]...)
+	}
+
+	// Non-PCM formats require a fact chunk.
+	fact := -1
+	if w.format == Float32 {
+		h = append(h, "fact"...)
+		h = le.AppendUint32(h, 4)
+		fact = len(h)
+		h = le.AppendUint32(h, uint32(frames)) // sample frames
+	}
+
+	h = append(h, "data"...)
+	h = le.AppendUint32(h, uint32(dataSize))
+	// ... sample data, padded to an even size
+
+	riffSize := int64(len(h)-8) + dataSize + dataSize&1 + int64(w.trailerSize)
+	if riffSize <= maxRIFFSize {
+		le.PutUint32(h[0x04:], uint32(riffSize))
+		return h
+	}
+
+	// Promote to RF64, 32-bit sizes are replaced by -1 and the real 64-bit
+	// sizes go to the ds64 chunk.
+	copy(h[0x00:], "RF64")
+	le.PutUint32(h[0x04:], math.MaxUint32)
+	copy(h[0x0C:], "ds64")
+	le.PutUint64(h[0x14:], uint64(riffSize))
+	le.PutUint64(h[0x1C:], uint64(dataSize))
+	le.PutUint64(h[0x24:], frames)
+	if fact >= 0 {
+		le.PutUint32(h[fact:], math.MaxUint32)
+	}
+	le.PutUint32(h[len(h)-4:], math.MaxUint32)
 	return h
 }
 
+// EnableStereo sets the wave file to 2 interleaved channels.
 func (w *Writer) EnableStereo() {
-	w.chanCount = 2
+	w.SetChannels(2)
 }
 
+// SetChannels sets the number of interleaved channels. Unless set with
+// SetChannelMask, the channel mask is set to the conventional speaker
+// assignment for n channels (e.g. 5.1 for 6 channels). It panics if called
+// after samples have been written.
+func (w *Writer) SetChannels(n int) {
+	if n < 1 || n > math.MaxUint16 {
+		panic("wave: invalid channel count")
+	}
+	w.checkNotStarted()
+	w.chanCount = n
+	if !w.customMask {
+		w.chanMask = defaultMask(n)
+	}
+}
+
+// SetChannelMask sets the speakers the channels map to, as a combination of
+// the Speaker constants. It forces the use of WAVE_FORMAT_EXTENSIBLE. It
+// panics if called after samples have been written.
+func (w *Writer) SetChannelMask(mask uint32) {
+	w.checkNotStarted()
+	w.chanMask = mask
+	w.customMask = true
+}
+
+// SetFormat sets the encoding of samples in the file. Samples passed to the
+// Write methods are converted to this format. It panics if called after
+// samples have been written.
+func (w *Writer) SetFormat(f SampleFormat) {
+	w.checkNotStarted()
+	w.format = f
+}
+
+func (w *Writer) checkNotStarted() {
+	if w.out.Started() {
+		panic("wave: format changed after samples were written")
+	}
+}
+
+// SetInfo sets the tags written to the LIST/INFO chunk of the file.
+func (w *Writer) SetInfo(info Info) {
+	w.meta.info = info
+}
+
+// AddCue adds a cue marker with the given label at the current position, that
+// is after the last sample written. It returns the cue ID.
+func (w *Writer) AddCue(label string) uint32 {
+	return w.AddCueAt(w.sampleCount/w.chanCount, label)
+}
+
+// AddCueAt adds a cue marker with the given label at the given sample frame.
+// It returns the cue ID.
+func (w *Writer) AddCueAt(frame int, label string) uint32 {
+	id := uint32(len(w.meta.cues) + 1)
+	w.meta.cues = append(w.meta.cues, Cue{ID: id, Position: frame, Label: label})
+	return id
+}
+
+// AddLoop adds a sampler loop, written to the smpl chunk of the file.
+func (w *Writer) AddLoop(l Loop) {
+	w.meta.loops = append(w.meta.loops, l)
+}
+
+// SampleCount returns the number of samples written so far.
 func (w *Writer) SampleCount() int {
 	return w.sampleCount
 }
 
+// Write writes 16-bit signed samples. Multichannel samples must be
+// interleaved.
 func (w *Writer) Write(p []int16) (n int, err error) {
-	remain := len(p)
-	w.sampleCount += remain
+	return write(w, p, func(b []byte, s int16) { w.format.putInt(b, int32(s)<<16) })
+}
+
+// WriteInt32 writes full scale 32-bit signed samples. Multichannel samples
+// must be interleaved.
+func (w *Writer) WriteInt32(p []int32) (n int, err error) {
+	return write(w, p, w.format.putInt)
+}
 
-	for remain != 0 {
-		var buf [4096]byte
+// WriteFloat32 writes samples in the [-1, 1] range. Multichannel samples must
+// be interleaved.
+func (w *Writer) WriteFloat32(p []float32) (n int, err error) {
+	return write(w, p, w.format.putFloat)
+}
 
-		n := len(buf) / sampleSize
-		if n > remain {
-			n = remain
+// write encodes samples with put and writes them.
+func write[T any](w *Writer, p []T, put func([]byte, T)) (n int, err error) {
+	if !w.out.Started() {
+		if err := w.out.Begin(w.header()); err != nil {
+			return 0, err
 		}
-		remain -= n
+	}
 
-		for i := range p {
-			binary.LittleEndian.PutUint16(buf[i*2:], uint16(p[i]))
+	size := w.format.Size()
+	for n < len(p) {
+		chunk := p[n:min(len(p), n+len(w.buf)/size)]
+		for i, s := range chunk {
+			put(w.buf[i*size:], s)
+		}
+		if _, err := w.out.Write(w.buf[:len(chunk)*size]); err != nil {
+			return n, err
 		}
-		w.bb.Write(buf[:len(p)*2])
+		n += len(chunk)
+		w.sampleCount += len(chunk)
 	}
-	return len(p), nil
+	return n, nil
 }
 
 // Close finalizes the wave file. It must be called when done writing samples.
 func (w *Writer) Close() error {
-	hdr := w.header()
-	if _, err := w.w.Write(hdr[:]); err != nil {
+	if err := w.finalize(); err != nil {
+		w.out.Close()
 		return err
 	}
-	if _, err := w.w.Write(w.bb.Bytes()); err != nil {
-		return err
+	return w.out.Close()
+}
+
+func (w *Writer) finalize() error {
+	if !w.out.Started() {
+		if err := w.out.Begin(w.header()); err != nil {
+			return err
+		}
 	}
 
-	w.bb.Reset()
-	w.sampleCount = 0
-	w.chanCount = 1
+	// Metadata chunks follow sample data and its pad byte.
+	trailer := w.meta.encode(w.sampleRate)
+	if w.dataSize()&1 != 0 {
+		trailer = append([]byte{0}, trailer...)
+	}
+	if _, err := w.out.Write(trailer); err != nil {
+		return err
+	}
+	w.trailerSize = len(trailer) - int(w.dataSize()&1)
 
-	return w.w.Close()
+	return w.out.Finish(w.header())
 }
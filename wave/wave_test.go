@@ -0,0 +1,681 @@
+package wave
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/arl/blip/internal/pcmtest"
+	"github.com/google/go-cmp/cmp"
+)
+
+// headerSize is the size of a PCM wave header, including the JUNK chunk
+// reserved for RF64 promotion.
+const headerSize = 0x2C + 8 + ds64Size
+
+func TestWriterSeekable(t *testing.T) {
+	samples := pcmtest.Samples(10000)
+
+	// Non-seekable destination, sample data is buffered.
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.EnableStereo()
+	if n, err := w.Write(samples); n != len(samples) || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Seekable destination, header is patched on Close.
+	path := filepath.Join(t.TempDir(), "out.wav")
+	fw, err := NewFile(path, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.EnableStereo()
+	for i := 0; i < len(samples); i += 3000 {
+		fw.Write(samples[i:min(i+3000, len(samples))])
+	}
+	if fw.SampleCount() != len(samples) {
+		t.Errorf("SampleCount = %d, want %d", fw.SampleCount(), len(samples))
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, buf.Bytes()) {
+		t.Fatalf("seekable and non-seekable outputs differ")
+	}
+	if len(got) != headerSize+2*len(samples) {
+		t.Fatalf("file size = %d, want %d", len(got), headerSize+2*len(samples))
+	}
+}
+
+func TestWriterStreams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.wav")
+	w, err := NewFile(path, 44100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	w.Write(pcmtest.Samples(5000))
+
+	// Sample data must already be in the file, after the placeholder header.
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != headerSize+2*5000 {
+		t.Errorf("file size = %d before Close, want %d", fi.Size(), headerSize+2*5000)
+	}
+}
+
+// buildWave builds a wave file with the given format tag, channel count, bits
+// per sample and raw sample data. An unknown chunk is inserted before the
+// data chunk.
+func buildWave(tag uint16, channels, bits int, data []byte) []byte {
+	le := binary.LittleEndian
+	align := channels * bits / 8
+
+	var b []byte
+	b = append(b, "RIFF\x00\x00\x00\x00WAVE"...)
+	b = append(b, "fmt \x10\x00\x00\x00"...)
+	b = le.AppendUint16(b, tag)
+	b = le.AppendUint16(b, uint16(channels))
+	b = le.AppendUint32(b, 48000)
+	b = le.AppendUint32(b, uint32(48000*align))
+	b = le.AppendUint16(b, uint16(align))
+	b = le.AppendUint16(b, uint16(bits))
+	b = append(b, "junk\x03\x00\x00\x00abc\x00"...)
+	b = append(b, "data"...)
+	b = le.AppendUint32(b, uint32(len(data)))
+	b = append(b, data...)
+	le.PutUint32(b[4:], uint32(len(b)-8))
+	return b
+}
+
+func TestReaderFormats(t *testing.T) {
+	le := binary.LittleEndian
+	tests := []struct {
+		name  string
+		tag   uint16
+		bits  int
+		data  []byte
+		int16 []int16
+		float []float32
+	}{
+		{
+			name:  "8-bit",
+			tag:   1,
+			bits:  8,
+			data:  []byte{0x00, 0x80, 0xc0},
+			int16: []int16{-32768, 0, 16384},
+			float: []float32{-1, 0, 0.5},
+		},
+		{
+			name:  "16-bit",
+			tag:   1,
+			bits:  16,
+			data:  le.AppendUint16(le.AppendUint16(nil, 0x8000), 0x4000),
+			int16: []int16{-32768, 16384},
+			float: []float32{-1, 0.5},
+		},
+		{
+			name:  "24-bit",
+			tag:   1,
+			bits:  24,
+			data:  []byte{0x00, 0x00, 0x80, 0xff, 0xff, 0x3f},
+			int16: []int16{-32768, 16383},
+			float: []float32{-1, 0x3fffff00 / float32(1<<31)},
+		},
+		{
+			name:  "32-bit",
+			tag:   1,
+			bits:  32,
+			data:  le.AppendUint32(le.AppendUint32(nil, 0x80000000), 0x40000000),
+			int16: []int16{-32768, 16384},
+			float: []float32{-1, 0.5},
+		},
+		{
+			name:  "float",
+			tag:   3,
+			bits:  32,
+			data:  le.AppendUint32(le.AppendUint32(nil, math.Float32bits(-2)), math.Float32bits(0.5)),
+			int16: []int16{-32768, 16384},
+			float: []float32{-2, 0.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := buildWave(tt.tag, 1, tt.bits, tt.data)
+
+			r, err := NewReader(bytes.NewReader(file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Channels() != 1 || r.SampleRate() != 48000 || r.BitsPerSample() != tt.bits {
+				t.Fatalf("format = %d channels, %d Hz, %d bits", r.Channels(), r.SampleRate(), r.BitsPerSample())
+			}
+			if r.SampleCount() != len(tt.int16) {
+				t.Errorf("SampleCount = %d, want %d", r.SampleCount(), len(tt.int16))
+			}
+			got, err := r.ReadAllInt16()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.int16); diff != "" {
+				t.Errorf("int16 samples mismatch (-got +want):\n%s", diff)
+			}
+
+			r, _ = NewReader(bytes.NewReader(file))
+			gotf, err := r.ReadAllFloat32()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(gotf, tt.float); diff != "" {
+				t.Errorf("float samples mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	valid := buildWave(1, 1, 16, []byte{1, 2, 3, 4})
+
+	tests := map[string]struct {
+		file []byte
+		err  error
+	}{
+		"not riff":    {[]byte("RIFX\x00\x00\x00\x00WAVE"), ErrFormat},
+		"no data":     {valid[:36], ErrFormat},
+		"bad align":   {bytes.Replace(valid, []byte{2, 0, 16, 0}, []byte{4, 0, 16, 0}, 1), ErrFormat},
+		"12-bit":      {buildWave(1, 1, 12, nil), ErrUnsupported},
+		"64-bit":      {buildWave(3, 1, 64, nil), ErrUnsupported},
+		"compression": {buildWave(2, 1, 4, nil), ErrUnsupported},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewReader(bytes.NewReader(tt.file)); !errors.Is(err, tt.err) {
+				t.Errorf("err = %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// Truncated sample data.
+	r, err := NewReader(bytes.NewReader(valid[:len(valid)-2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]int16, 4)
+	if n, err := r.ReadInt16(buf); n != 1 || err != nil {
+		t.Fatalf("ReadInt16 = %d, %v, want 1, nil", n, err)
+	}
+	if _, err := r.ReadInt16(buf); err != io.ErrUnexpectedEOF {
+		t.Fatalf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReaderHugeData(t *testing.T) {
+	// The data chunk claims much more than the file holds.
+	file := buildWave(1, 1, 16, make([]byte, 100))
+	binary.LittleEndian.PutUint32(file[len(file)-104:], 0xfffffff0)
+
+	r, err := NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []int16
+	alloc := pcmtest.Allocated(func() { got, err = r.ReadAllInt16() })
+	if err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+	if len(got) != 50 {
+		t.Errorf("read %d samples, want 50", len(got))
+	}
+	if alloc > 1<<20 {
+		t.Errorf("allocated %d bytes", alloc)
+	}
+}
+
+func TestReaderHugeChunks(t *testing.T) {
+	valid := buildWave(1, 1, 16, make([]byte, 100))
+	for _, id := range []string{"fmt ", "junk"} {
+		// The chunk claims the largest possible size.
+		file := bytes.Clone(valid)
+		i := bytes.Index(file, []byte(id))
+		binary.LittleEndian.PutUint32(file[i+4:], math.MaxUint32)
+
+		var err error
+		alloc := pcmtest.Allocated(func() { _, err = NewReader(bytes.NewReader(file)) })
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("%q chunk: err = %v, want ErrFormat", id, err)
+		}
+		if alloc > 1<<20 {
+			t.Errorf("%q chunk: allocated %d bytes", id, alloc)
+		}
+	}
+}
+
+func TestReaderRoundTrip(t *testing.T) {
+	samples := pcmtest.Samples(10000)
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 22050)
+	w.EnableStereo()
+	w.Write(samples)
+	w.Close()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Channels() != 2 || r.SampleRate() != 22050 {
+		t.Errorf("format = %d channels, %d Hz", r.Channels(), r.SampleRate())
+	}
+	got, err := r.ReadAllInt16()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, samples); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestReaderGolden(t *testing.T) {
+	paths, err := filepath.Glob("../testdata/*/out.wav")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no golden files: %v", err)
+	}
+	for _, path := range paths {
+		r, err := Open(path)
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		n := r.SampleCount()
+		samples, err := r.ReadAllInt16()
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if len(samples) != n || n == 0 {
+			t.Errorf("%s: read %d samples, want %d", path, len(samples), n)
+		}
+		if r.SampleRate() != 44100 || r.BitsPerSample() != 16 {
+			t.Errorf("%s: format = %d Hz, %d bits", path, r.SampleRate(), r.BitsPerSample())
+		}
+	}
+}
+
+func TestWriterFormats(t *testing.T) {
+	samples := []int16{-32768, -16384, 0, 256, 16384, 32767}
+
+	tests := []struct {
+		format     SampleFormat
+		bits       int
+		extensible bool
+		want       []int16 // samples read back, if not identical
+	}{
+		{format: PCM8, bits: 8, want: []int16{-32768, -16384, 0, 256, 16384, 32512}},
+		{format: PCM16, bits: 16},
+		{format: PCM24, bits: 24, extensible: true},
+		{format: PCM32, bits: 32, extensible: true},
+		{format: Float32, bits: 32, extensible: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.bits), func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewWriter(&buf, 48000)
+			w.SetFormat(tt.format)
+			w.Write(samples)
+			if err := w.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// Sample data is padded to an even size.
+			if buf.Len()%2 != 0 {
+				t.Errorf("odd file size %d", buf.Len())
+			}
+			if ext := binary.LittleEndian.Uint16(buf.Bytes()[0x38:]) == formatExtensible; ext != tt.extensible {
+				t.Errorf("extensible = %t, want %t", ext, tt.extensible)
+			}
+
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.BitsPerSample() != tt.bits || r.Float() != (tt.format == Float32) {
+				t.Errorf("format = %d bits, float %t", r.BitsPerSample(), r.Float())
+			}
+			got, err := r.ReadAllInt16()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := tt.want
+			if want == nil {
+				want = samples
+			}
+			if diff := cmp.Diff(got, want); diff != "" {
+				t.Errorf("samples mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriterHighResolution(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 96000)
+	w.SetFormat(PCM24)
+	w.WriteInt32([]int32{0x12345678, -0x12345678})
+	w.WriteFloat32([]float32{0.5, -2})
+	w.Close()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.ReadAllFloat32()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []float32{
+		float32(0x12345600) / (1 << 31),
+		float32(-0x12345700) / (1 << 31),
+		0.5,
+		-1,
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("samples mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestWriterMultichannel(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 48000)
+	w.SetChannels(6)
+	w.Write(pcmtest.Samples(6 * 100))
+	w.Close()
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Channels() != 6 {
+		t.Errorf("channels = %d, want 6", r.Channels())
+	}
+	const mask = SpeakerFrontLeft | SpeakerFrontRight | SpeakerFrontCenter |
+		SpeakerLowFrequency | SpeakerBackLeft | SpeakerBackRight
+	if r.ChannelMask() != mask {
+		t.Errorf("channel mask = %#x, want %#x", r.ChannelMask(), mask)
+	}
+
+	// Explicit mask forces extensible format, even for stereo.
+	buf.Reset()
+	w = NewWriter(&buf, 48000)
+	w.EnableStereo()
+	w.SetChannelMask(SpeakerSideLeft | SpeakerSideRight)
+	w.Write(pcmtest.Samples(2))
+	w.Close()
+	if r, err = NewReader(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if r.ChannelMask() != SpeakerSideLeft|SpeakerSideRight {
+		t.Errorf("channel mask = %#x", r.ChannelMask())
+	}
+
+	shouldPanic(t, func() { w.SetChannels(4) })
+}
+
+func shouldPanic(t *testing.T, f func()) {
+	t.Helper()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	f()
+}
+
+func TestRF64(t *testing.T) {
+	defer func(max int64) { maxRIFFSize = max }(maxRIFFSize)
+	maxRIFFSize = 1000
+
+	samples := pcmtest.Samples(1000)
+	for _, format := range []SampleFormat{PCM16, Float32} {
+		path := filepath.Join(t.TempDir(), "out.wav")
+		w, err := NewFile(path, 44100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetFormat(format)
+		w.Write(samples)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(file[:4]) != "RF64" || string(file[12:16]) != "ds64" {
+			t.Fatalf("file not promoted to RF64: %q", file[:16])
+		}
+		riffSize := binary.LittleEndian.Uint64(file[0x14:])
+		if riffSize != uint64(len(file)-8) {
+			t.Errorf("ds64 RIFF size = %d, want %d", riffSize, len(file)-8)
+		}
+
+		r, err := NewReader(bytes.NewReader(file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.SampleCount() != len(samples) {
+			t.Errorf("SampleCount = %d, want %d", r.SampleCount(), len(samples))
+		}
+		got, err := r.ReadAllInt16()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, samples); diff != "" {
+			t.Errorf("samples mismatch (-got +want):\n%s", diff)
+		}
+	}
+}
+
+func TestRF64HugeData(t *testing.T) {
+	defer func(max int64) { maxRIFFSize = max }(maxRIFFSize)
+	maxRIFFSize = 100
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.Write(pcmtest.Samples(100))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+	if string(file[:4]) != "RF64" {
+		t.Fatalf("file not promoted to RF64: %q", file[:4])
+	}
+
+	// The ds64 data size claims much more than the file holds.
+	huge := bytes.Clone(file)
+	binary.LittleEndian.PutUint64(huge[0x1c:], 1<<62)
+	r, err := NewReader(bytes.NewReader(huge))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadAllInt16(); err != io.ErrUnexpectedEOF {
+		t.Errorf("err = %v, want io.ErrUnexpectedEOF", err)
+	}
+
+	binary.LittleEndian.PutUint64(huge[0x1c:], 1<<63)
+	if _, err := NewReader(bytes.NewReader(huge)); !errors.Is(err, ErrFormat) {
+		t.Errorf("err = %v, want %v", err, ErrFormat)
+	}
+
+	// The ds64 chunk claims the largest possible size, or a size too small
+	// for its fields.
+	for _, size := range []uint32{math.MaxUint32, ds64Size - 1} {
+		huge := bytes.Clone(file)
+		binary.LittleEndian.PutUint32(huge[0x10:], size)
+		alloc := pcmtest.Allocated(func() { _, err = NewReader(bytes.NewReader(huge)) })
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("ds64 size %#x: err = %v, want ErrFormat", size, err)
+		}
+		if alloc > 1<<20 {
+			t.Errorf("ds64 size %#x: allocated %d bytes", size, alloc)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	info := Info{
+		Title:    "Song",
+		Artist:   "Composer",
+		Comment:  "Recorded with blip",
+		Software: "demo_chip",
+	}
+	loops := []Loop{
+		{Type: LoopForward, Start: 100, End: 499},
+		{Type: LoopPingPong, Start: 0, End: 9, PlayCount: 3},
+	}
+
+	write := func(w *Writer) {
+		w.SetFormat(PCM24) // odd data size, needs a pad byte
+		w.SetInfo(info)
+		w.Write(pcmtest.Samples(100))
+		w.AddCue("frame 1")
+		w.Write(pcmtest.Samples(401))
+		w.AddCue("")
+		w.AddCueAt(20, "intro")
+		for _, l := range loops {
+			w.AddLoop(l)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wantCues := []Cue{
+		{ID: 1, Position: 100, Label: "frame 1"},
+		{ID: 2, Position: 501},
+		{ID: 3, Position: 20, Label: "intro"},
+	}
+
+	check := func(t *testing.T, r *Reader, seekable bool) {
+		t.Helper()
+		if seekable {
+			// Trailing metadata is available before reading samples.
+			if diff := cmp.Diff(r.Info(), info); diff != "" {
+				t.Errorf("info mismatch (-got +want):\n%s", diff)
+			}
+		}
+		samples, err := r.ReadAllInt16()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(samples) != 501 {
+			t.Errorf("read %d samples, want 501", len(samples))
+		}
+		if diff := cmp.Diff(r.Info(), info); diff != "" {
+			t.Errorf("info mismatch (-got +want):\n%s", diff)
+		}
+		if diff := cmp.Diff(r.Cues(), wantCues); diff != "" {
+			t.Errorf("cues mismatch (-got +want):\n%s", diff)
+		}
+		if diff := cmp.Diff(r.Loops(), loops); diff != "" {
+			t.Errorf("loops mismatch (-got +want):\n%s", diff)
+		}
+	}
+
+	t.Run("seekable", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "out.wav")
+		w, err := NewFile(path, 44100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(w)
+
+		file, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := binary.LittleEndian.Uint32(file[4:]); int(size) != len(file)-8 {
+			t.Errorf("RIFF size = %d, want %d", size, len(file)-8)
+		}
+
+		r, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		check(t, r, true)
+	})
+
+	t.Run("non-seekable", func(t *testing.T) {
+		var buf bytes.Buffer
+		write(NewWriter(&buf, 44100))
+
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(t, r, false)
+	})
+}
+
+func TestMetadataHugeChunks(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 44100)
+	w.SetInfo(Info{Title: "Song"})
+	w.Write(pcmtest.Samples(100))
+	w.AddCueAt(20, "intro")
+	w.AddLoop(Loop{Start: 0, End: 9})
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+
+	tests := []struct {
+		name string
+		id   string // chunk holding the corrupted field
+		off  int    // offset of the field from the start of the chunk
+	}{
+		{"LIST chunk size", "LIST", 4},
+		{"INFO subchunk size", "INAM", 4},
+		{"labl subchunk size", "labl", 4},
+		{"cue count", "cue ", 8},
+		{"smpl loop count", "smpl", 8 + 28},
+	}
+	for _, tt := range tests {
+		file := bytes.Clone(valid)
+		i := bytes.Index(file, []byte(tt.id))
+		if i < 0 {
+			t.Fatalf("no %q chunk", tt.id)
+		}
+		binary.LittleEndian.PutUint32(file[i+tt.off:], math.MaxUint32)
+
+		var err error
+		alloc := pcmtest.Allocated(func() { _, err = NewReader(bytes.NewReader(file)) })
+		if !errors.Is(err, ErrFormat) {
+			t.Errorf("%s: err = %v, want ErrFormat", tt.name, err)
+		}
+		if alloc > 1<<20 {
+			t.Errorf("%s: allocated %d bytes", tt.name, alloc)
+		}
+	}
+}